@@ -2,12 +2,18 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/envercigal/golang/internal/adapter/http"
+	"github.com/envercigal/golang/internal/adapter/middleware"
 	repo "github.com/envercigal/golang/internal/adapter/repository"
+	"github.com/envercigal/golang/internal/core/domain"
 	"github.com/envercigal/golang/internal/core/service"
 	circuitbreaker "github.com/envercigal/golang/pkg"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -16,7 +22,8 @@ import (
 )
 
 func main() {
-	app := fiber.New()
+	// stream large request bodies to the handlers instead of buffering them
+	app := fiber.New(fiber.Config{StreamRequestBody: true})
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -31,12 +38,127 @@ func main() {
 		log.Fatal(err)
 	}
 
-	repository := repo.NewDriverLocationRepo(client.Database("mydb"))
-	circuitBreaker := circuitbreaker.New(5, 1)
-	svc := service.NewDriverLocationService(repository, circuitBreaker)
+	db := client.Database("mydb")
+	repository, err := repo.NewDriverLocationRepo(db,
+		repo.WithCurrentTTL(durationEnv("CURRENT_LOCATION_TTL", 0)),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	importJobs, err := repo.NewImportJobRepo(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	deadLetters, err := repo.NewDeadLetterRepo(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	breakers := circuitbreaker.NewRegistry()
+	circuitBreaker := circuitbreaker.New(5, durationEnv("BREAKER_RESET_TIMEOUT", 30*time.Second),
+		circuitbreaker.WithName("mongo"),
+		circuitbreaker.WithTimeout(durationEnv("QUERY_TIMEOUT", 5*time.Second)),
+		// empty results and rejected queries say nothing about the database
+		circuitbreaker.WithIsSuccessful(func(err error) bool {
+			return errors.Is(err, mg.ErrNoDocuments) ||
+				errors.Is(err, domain.ErrNotFound) ||
+				errors.Is(err, domain.ErrInvalidArgument)
+		}),
+		circuitbreaker.OnStateChange(func(name string, from, to circuitbreaker.State) {
+			log.Printf("circuit breaker %s: %s -> %s", name, from, to)
+		}),
+	)
+	breakers.Add(circuitBreaker)
+	svc := service.NewDriverLocationService(repository, circuitBreaker,
+		service.WithMaxAge(durationEnv("DRIVER_MAX_AGE", 5*time.Minute)),
+		service.WithImportJobStore(importJobs),
+		service.WithDeadLetterStore(deadLetters),
+		service.WithImportWorkers(intEnv("IMPORT_WORKERS", 0)),
+		service.WithImportBatchSize(intEnv("IMPORT_BATCH_SIZE", 0)),
+		service.WithImportWriteLatency(durationEnv("IMPORT_WRITE_LATENCY", 0)),
+	)
 
-	http.RegisterDriverRoutes(app, svc)
+	auth, err := authConfig(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	http.RegisterDriverRoutes(app, svc, auth)
+	http.RegisterAdminRoutes(app, breakers, auth)
 
 	log.Println("Listening on :3000")
 	log.Fatal(app.Listen(":3000"))
 }
+
+// authConfig builds the token verification settings. JWT_HS256_SECRETS is a
+// comma separated list of secrets, JWT_RS256_PUBLIC_KEYS and
+// JWT_ES256_PUBLIC_KEYS are comma separated lists of PEM file paths and
+// JWT_JWKS is the path or URL of a JSON Web Key Set.
+func authConfig(ctx context.Context) (middleware.AuthConfig, error) {
+	cfg := middleware.AuthConfig{
+		Issuer:   os.Getenv("JWT_ISSUER"),
+		Audience: os.Getenv("JWT_AUDIENCE"),
+	}
+	for _, secret := range listEnv("JWT_HS256_SECRETS") {
+		cfg.HMACSecrets = append(cfg.HMACSecrets, []byte(secret))
+	}
+	for _, path := range listEnv("JWT_RS256_PUBLIC_KEYS") {
+		key, err := middleware.LoadRSAPublicKey(path)
+		if err != nil {
+			return cfg, fmt.Errorf("load RS256 key %s: %w", path, err)
+		}
+		cfg.RSAPublicKeys = append(cfg.RSAPublicKeys, key)
+	}
+	for _, path := range listEnv("JWT_ES256_PUBLIC_KEYS") {
+		key, err := middleware.LoadECDSAPublicKey(path)
+		if err != nil {
+			return cfg, fmt.Errorf("load ES256 key %s: %w", path, err)
+		}
+		cfg.ECDSAPublicKeys = append(cfg.ECDSAPublicKeys, key)
+	}
+	if source := os.Getenv("JWT_JWKS"); source != "" {
+		jwks, err := middleware.NewJWKS(ctx, source, durationEnv("JWT_JWKS_REFRESH", 15*time.Minute))
+		if err != nil {
+			return cfg, fmt.Errorf("load jwks %s: %w", source, err)
+		}
+		go jwks.Run(ctx)
+		cfg.JWKS = jwks
+	}
+	if len(cfg.HMACSecrets) == 0 && len(cfg.RSAPublicKeys) == 0 && len(cfg.ECDSAPublicKeys) == 0 && cfg.JWKS == nil {
+		return cfg, errors.New("no JWT verification key configured")
+	}
+	return cfg, nil
+}
+
+func listEnv(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func durationEnv(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+	return d
+}
+
+func intEnv(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("invalid %s: %v", key, err)
+	}
+	return n
+}
@@ -0,0 +1,27 @@
+package http
+
+import (
+	"bytes"
+	circuitbreaker "github.com/envercigal/golang/pkg"
+	"github.com/gofiber/fiber/v2"
+)
+
+// BreakersHandler returns the state and call totals of every circuit breaker.
+func BreakersHandler(breakers *circuitbreaker.Registry) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(breakers.Snapshots())
+	}
+}
+
+// MetricsHandler serves the circuit breaker metrics in the Prometheus text
+// format.
+func MetricsHandler(breakers *circuitbreaker.Registry) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var buf bytes.Buffer
+		if err := breakers.WriteMetrics(&buf); err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+		return c.Send(buf.Bytes())
+	}
+}
@@ -0,0 +1,59 @@
+package http
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/envercigal/golang/internal/adapter/middleware"
+	circuitbreaker "github.com/envercigal/golang/pkg"
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupAdminApp(breakers *circuitbreaker.Registry) *fiber.App {
+	app := fiber.New()
+	RegisterAdminRoutes(app, breakers, middleware.AuthConfig{HMACSecrets: [][]byte{testSecret}})
+	return app
+}
+
+func TestBreakersHandler(t *testing.T) {
+	breakers := circuitbreaker.NewRegistry()
+	breakers.Add(circuitbreaker.New(5, time.Second, circuitbreaker.WithName("mongo")))
+	app := setupAdminApp(breakers)
+
+	req := httptest.NewRequest("GET", "/admin/breakers", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+
+	var body []map[string]any
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Len(t, body, 1)
+	assert.Equal(t, "mongo", body[0]["name"])
+	assert.Equal(t, "closed", body[0]["state"])
+
+	req = httptest.NewRequest("GET", "/admin/breakers", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTokenWith(jwt.MapClaims{"roles": []string{middleware.RoleDispatcher}}))
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 403, resp.StatusCode)
+}
+
+func TestMetricsHandler(t *testing.T) {
+	breakers := circuitbreaker.NewRegistry()
+	breakers.Add(circuitbreaker.New(5, time.Second, circuitbreaker.WithName("mongo")))
+	app := setupAdminApp(breakers)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/metrics", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Contains(t, string(body), `circuit_breaker_state{name="mongo"} 0`)
+}
@@ -0,0 +1,189 @@
+package http
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/envercigal/golang/internal/core/domain"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Limits that protect imports against decompression bombs. The ratio is only
+// enforced once ratioCheckAfter bytes were decompressed, so small but highly
+// repetitive files still pass.
+const (
+	maxDecompressedSize = 50 << 30
+	maxCompressionRatio = 100
+	ratioCheckAfter     = 16 << 20
+	maxZstdWindow       = 64 << 20
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte{'P', 'K', 0x03, 0x04}
+
+	errDecompressionBomb = errors.New("decompressed upload exceeds the size or compression ratio limit")
+)
+
+// decompress detects gzip, zstd and zip uploads by their magic bytes and
+// returns a reader of the decompressed content, together with the file name
+// of that content for format detection. Other uploads are returned as is.
+// Closing the returned reader closes f.
+func decompress(f tempFile, name string) (io.ReadCloser, string, error) {
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(len(zipMagic))
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, "", err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		in := &countingReader{r: br}
+		zr, err := gzip.NewReader(in)
+		if err != nil {
+			f.Close()
+			return nil, "", fmt.Errorf("%w: invalid gzip upload: %v", domain.ErrInvalidArgument, err)
+		}
+		if zr.Name != "" {
+			name = zr.Name
+		} else {
+			name = trimExt(name)
+		}
+		return &decompressedFile{
+			Reader: &bombGuard{r: zr, compressed: in.count},
+			close:  func() error { zr.Close(); return f.Close() },
+		}, name, nil
+
+	case bytes.HasPrefix(magic, zstdMagic):
+		in := &countingReader{r: br}
+		zr, err := zstd.NewReader(in, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxWindow(maxZstdWindow))
+		if err != nil {
+			f.Close()
+			return nil, "", err
+		}
+		return &decompressedFile{
+			Reader: &bombGuard{r: zr, compressed: in.count},
+			close:  func() error { zr.Close(); return f.Close() },
+		}, trimExt(name), nil
+
+	case bytes.HasPrefix(magic, zipMagic):
+		return unzip(f)
+
+	default:
+		return &decompressedFile{Reader: br, close: f.Close}, name, nil
+	}
+}
+
+// unzip opens the single file of a zip archive.
+func unzip(f tempFile) (io.ReadCloser, string, error) {
+	fail := func(err error) (io.ReadCloser, string, error) {
+		f.Close()
+		return nil, "", err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return fail(err)
+	}
+	// the sizes in the archive are not trusted, the guard counts the bytes
+	// the entry actually reads from the file
+	in := &countingReaderAt{r: f.File}
+	archive, err := zip.NewReader(in, info.Size())
+	if err != nil {
+		return fail(fmt.Errorf("%w: invalid zip upload: %v", domain.ErrInvalidArgument, err))
+	}
+
+	var entries []*zip.File
+	for _, entry := range archive.File {
+		if !entry.FileInfo().IsDir() {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) != 1 {
+		return fail(fmt.Errorf("%w: zip upload must contain exactly one file, found %d", domain.ErrInvalidArgument, len(entries)))
+	}
+	entry := entries[0]
+
+	directory := in.count()
+	rc, err := entry.Open()
+	if err != nil {
+		return fail(fmt.Errorf("%w: invalid zip upload: %v", domain.ErrInvalidArgument, err))
+	}
+	return &decompressedFile{
+		Reader: &bombGuard{r: rc, compressed: func() int64 { return in.count() - directory }},
+		close:  func() error { rc.Close(); return f.Close() },
+	}, filepath.Base(entry.Name), nil
+}
+
+// trimExt strips the compression extension, so "drivers.geojson.gz" is
+// detected as GeoJSON.
+func trimExt(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".gz", ".gzip", ".zst", ".zstd":
+		return strings.TrimSuffix(name, filepath.Ext(name))
+	default:
+		return name
+	}
+}
+
+type decompressedFile struct {
+	io.Reader
+	close func() error
+}
+
+func (d *decompressedFile) Close() error { return d.close() }
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) count() int64 { return c.n }
+
+type countingReaderAt struct {
+	r io.ReaderAt
+	n int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReaderAt) count() int64 { return c.n }
+
+// bombGuard fails the read once the decompressed size exceeds
+// maxDecompressedSize or grows beyond maxCompressionRatio times the
+// compressed bytes consumed so far.
+type bombGuard struct {
+	r          io.Reader
+	compressed func() int64
+	n          int64
+}
+
+func (g *bombGuard) Read(p []byte) (int, error) {
+	n, err := g.r.Read(p)
+	g.n += int64(n)
+	if g.n > maxDecompressedSize {
+		return n, errDecompressionBomb
+	}
+	if g.n > ratioCheckAfter && g.n > maxCompressionRatio*max(g.compressed(), 1) {
+		return n, errDecompressionBomb
+	}
+	return n, err
+}
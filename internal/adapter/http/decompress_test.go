@@ -0,0 +1,149 @@
+package http
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"hash/crc32"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/envercigal/golang/internal/core/domain"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+const plainCSV = "driver_id,lat,lon\n1,41,29\n"
+
+func writeTemp(t *testing.T, data []byte) tempFile {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "upload-*")
+	assert.NoError(t, err)
+	_, err = f.Write(data)
+	assert.NoError(t, err)
+	_, err = f.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	return tempFile{f}
+}
+
+func gzipped(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func zipped(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	enc, err := zstd.NewWriter(nil)
+	assert.NoError(t, err)
+	zstdData := enc.EncodeAll([]byte(plainCSV), nil)
+	assert.NoError(t, enc.Close())
+
+	for name, tc := range map[string]struct {
+		data     []byte
+		filename string
+		want     string
+	}{
+		"plain": {data: []byte(plainCSV), filename: "drivers.csv", want: "drivers.csv"},
+		"gzip":  {data: gzipped(t, []byte(plainCSV)), filename: "drivers.csv.gz", want: "drivers.csv"},
+		"zstd":  {data: zstdData, filename: "drivers.geojson.zst", want: "drivers.geojson"},
+		"zip":   {data: zipped(t, map[string]string{"export/drivers.geojson": plainCSV}), filename: "export.zip", want: "drivers.geojson"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			f := writeTemp(t, tc.data)
+			r, filename, err := decompress(f, tc.filename)
+			assert.NoError(t, err)
+			content, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.NoError(t, r.Close())
+
+			assert.Equal(t, plainCSV, string(content))
+			assert.Equal(t, tc.want, filename)
+			_, err = os.Stat(f.Name())
+			assert.True(t, os.IsNotExist(err), "spooled file is removed on close")
+		})
+	}
+}
+
+func TestDecompress_RejectsZipWithSeveralFiles(t *testing.T) {
+	f := writeTemp(t, zipped(t, map[string]string{"a.csv": plainCSV, "b.csv": plainCSV}))
+	_, _, err := decompress(f, "export.zip")
+	assert.ErrorIs(t, err, domain.ErrInvalidArgument)
+}
+
+func TestDecompress_StopsBombs(t *testing.T) {
+	f := writeTemp(t, gzipped(t, make([]byte, ratioCheckAfter+1<<20)))
+	r, _, err := decompress(f, "zeros.gz")
+	assert.NoError(t, err)
+	defer r.Close()
+
+	_, err = io.Copy(io.Discard, r)
+	assert.ErrorIs(t, err, errDecompressionBomb)
+}
+
+// zippedClaiming zips data with a header claiming a compressed size claim
+// times the real one.
+func zippedClaiming(t *testing.T, data []byte, claim uint64) []byte {
+	t.Helper()
+	var compressed bytes.Buffer
+	fw, err := flate.NewWriter(&compressed, flate.BestCompression)
+	assert.NoError(t, err)
+	_, err = fw.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, fw.Close())
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.CreateRaw(&zip.FileHeader{
+		Name:               "zeros.csv",
+		Method:             zip.Deflate,
+		CRC32:              crc32.ChecksumIEEE(data),
+		CompressedSize64:   claim * uint64(compressed.Len()),
+		UncompressedSize64: uint64(len(data)),
+	})
+	assert.NoError(t, err)
+	_, err = w.Write(compressed.Bytes())
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestDecompress_StopsZipBombsWithForgedSizes(t *testing.T) {
+	for name, claim := range map[string]uint64{"honest": 1, "forged": 1000} {
+		t.Run(name, func(t *testing.T) {
+			data := zippedClaiming(t, make([]byte, ratioCheckAfter+4<<20), claim)
+			archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			assert.NoError(t, err)
+			if claim > 1 {
+				// the header claims a ratio the guard would let through
+				assert.Greater(t, archive.File[0].CompressedSize64*maxCompressionRatio, uint64(ratioCheckAfter+4<<20))
+			}
+
+			r, _, err := decompress(writeTemp(t, data), "zeros.zip")
+			assert.NoError(t, err)
+			defer r.Close()
+
+			_, err = io.Copy(io.Discard, r)
+			assert.ErrorIs(t, err, errDecompressionBomb)
+		})
+	}
+}
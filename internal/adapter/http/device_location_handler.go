@@ -1,15 +1,32 @@
 package http
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/csv"
+	"encoding/json"
 	"errors"
+	"github.com/envercigal/golang/internal/adapter/middleware"
 	"github.com/envercigal/golang/internal/core/domain"
 	"github.com/envercigal/golang/internal/core/port"
 	circuitbreaker "github.com/envercigal/golang/pkg"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/mongo"
+	"io"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultNearestLimit = 10
+	defaultPageSize     = 50
+
+	maxIdempotencyKey       = 255
+	defaultTrajectoryWindow = 24 * time.Hour
 )
 
 func CreateDriverHandler(svc port.DriverLocationService) fiber.Handler {
@@ -18,6 +35,11 @@ func CreateDriverHandler(svc port.DriverLocationService) fiber.Handler {
 		if err := c.BodyParser(&dl); err != nil {
 			return fiber.ErrBadRequest
 		}
+		if !middleware.HasRole(c, middleware.RoleAdmin) {
+			if id, ok := middleware.DriverID(c); !ok || id != dl.DriverID {
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "drivers may only report their own location"})
+			}
+		}
 		created, err := svc.Create(c.Context(), &dl)
 		if err != nil {
 			return fiber.NewError(fiber.StatusBadRequest, err.Error())
@@ -32,22 +54,153 @@ func ImportDriversHandler(svc port.DriverLocationService) fiber.Handler {
 		if err != nil {
 			return fiber.ErrBadRequest
 		}
-		f, err := file.Open()
+		f, checksum, err := spoolUpload(file)
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		content, name, err := decompress(f, file.Filename)
+		if errors.Is(err, domain.ErrInvalidArgument) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		if err != nil {
+			return fiber.ErrInternalServerError
+		}
+		opts, err := importOptions(c, file.Header.Get("Content-Type"), name)
+		if err != nil {
+			content.Close()
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+		if c.QueryBool("dryRun") {
+			return validateUpload(c, svc, content, opts)
+		}
+		// re-uploading the same content with the same options resumes or
+		// returns the earlier import
+		opts.Key = c.Get("Idempotency-Key")
+		if len(opts.Key) > maxIdempotencyKey {
+			content.Close()
+			return fiber.NewError(fiber.StatusBadRequest, "Idempotency-Key is too long")
+		}
+		if opts.Key == "" {
+			opts.Key = uploadKey(checksum, opts)
+		}
 
+		job, err := svc.StartImport(c.UserContext(), content, opts)
+		if errors.Is(err, domain.ErrInvalidArgument) {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
 		if err != nil {
 			return fiber.ErrInternalServerError
 		}
 
-		bulkErr := svc.BulkCreate(context.Background(), f)
+		return c.Status(fiber.StatusAccepted).JSON(job)
+	}
+}
 
-		if bulkErr != nil {
-			return fiber.ErrInternalServerError
+// validateUpload parses the upload without writing it and responds with the
+// summary of its rows.
+func validateUpload(c *fiber.Ctx, svc port.DriverLocationService, content io.ReadCloser, opts domain.ImportOptions) error {
+	defer content.Close()
+
+	summary, err := svc.ValidateImport(c.UserContext(), content, opts)
+	if errors.Is(err, domain.ErrInvalidArgument) {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return fiber.ErrInternalServerError
+	}
+	return c.Status(fiber.StatusOK).JSON(summary)
+}
+
+// StreamDriversHandler ingests newline delimited JSON location updates from
+// the request body as they arrive. The response is a stream of NDJSON
+// acknowledgements with the running totals of persisted and rejected lines,
+// so clients see their updates being stored while they keep sending.
+// Malformed lines are reported in the acknowledgements instead of aborting
+// the stream.
+func StreamDriversHandler(svc port.DriverLocationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var body io.Reader = c.Context().RequestBodyStream()
+		if body == nil {
+			body = bytes.NewReader(c.Body())
 		}
 
-		return c.Status(fiber.StatusOK).JSON(fiber.Map{"status": "import success"})
+		// the request body is read while the acknowledgements are written
+		ctx, cancel := context.WithCancel(c.UserContext())
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer cancel()
+			enc := json.NewEncoder(w)
+			ack := func(ack *domain.StreamAck) {
+				if err := enc.Encode(ack); err != nil {
+					cancel()
+					return
+				}
+				if err := w.Flush(); err != nil {
+					cancel() // the client is gone
+				}
+			}
+			if err := svc.StreamCreate(ctx, body, ack); err != nil && !errors.Is(err, context.Canceled) {
+				log.Printf("stream error: %v", err)
+			}
+		})
+		return nil
 	}
 }
 
+func ImportJobHandler(svc port.DriverLocationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		job, err := svc.ImportJob(c.UserContext(), c.Params("id"))
+		if err != nil {
+			return jobError(err)
+		}
+		return c.Status(fiber.StatusOK).JSON(job)
+	}
+}
+
+func CancelImportHandler(svc port.DriverLocationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		job, err := svc.CancelImport(c.UserContext(), c.Params("id"))
+		if err != nil {
+			return jobError(err)
+		}
+		return c.Status(fiber.StatusOK).JSON(job)
+	}
+}
+
+func ImportReportHandler(svc port.DriverLocationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		report, err := svc.ImportReport(c.UserContext(), c.Params("id"))
+		if err != nil {
+			return jobError(err)
+		}
+
+		switch c.Query("format", "json") {
+		case "json":
+			c.Attachment("import-" + c.Params("id") + "-report.json")
+			return c.Status(fiber.StatusOK).JSON(report)
+		case "csv":
+			c.Attachment("import-" + c.Params("id") + "-report.csv")
+			c.Set(fiber.HeaderContentType, "text/csv")
+			w := csv.NewWriter(c.Response().BodyWriter())
+			_ = w.Write([]string{"row", "reason"})
+			for _, e := range report.Errors {
+				_ = w.Write([]string{strconv.FormatInt(e.Row, 10), e.Reason})
+			}
+			w.Flush()
+			return w.Error()
+		default:
+			return fiber.NewError(fiber.StatusBadRequest, "format must be json or csv")
+		}
+	}
+}
+
+func jobError(err error) error {
+	if errors.Is(err, domain.ErrNotFound) {
+		return fiber.ErrNotFound
+	}
+	return fiber.ErrInternalServerError
+}
+
 func FindNearestHandler(svc port.DriverLocationService) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		lon, err1 := strconv.ParseFloat(c.Query("lon"), 64)
@@ -55,22 +208,239 @@ func FindNearestHandler(svc port.DriverLocationService) fiber.Handler {
 		if err1 != nil || err2 != nil {
 			return fiber.ErrBadRequest
 		}
+		filter, err := locationFilter(c)
+		if err != nil {
+			return err
+		}
 
-		driver, err := svc.FindNearest(c.Context(), lon, lat)
+		driver, err := svc.FindNearest(c.Context(), lon, lat, filter)
+		if err != nil {
+			return queryError(err)
+		}
+		return c.Status(http.StatusOK).JSON(driver)
+	}
+}
 
-		// Circuit breaker logic can be added here if needed.
-		// For testing purposes, only the HTTP status codes were changed.
-		switch {
-		case errors.Is(err, mongo.ErrNoDocuments):
-			return fiber.ErrNotFound
-		case errors.Is(err, circuitbreaker.ErrOpen):
-			return fiber.ErrServiceUnavailable
-		case errors.Is(err, circuitbreaker.ErrHalfOpen):
-			return fiber.ErrTooManyRequests
-		case err != nil:
-			return fiber.ErrInternalServerError
+func FindKNearestHandler(svc port.DriverLocationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lon, err1 := strconv.ParseFloat(c.Query("lon"), 64)
+		lat, err2 := strconv.ParseFloat(c.Query("lat"), 64)
+		maxDistance, err3 := strconv.ParseFloat(c.Query("maxDistance", "0"), 64)
+		limit, err4 := strconv.Atoi(c.Query("limit", strconv.Itoa(defaultNearestLimit)))
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			return fiber.ErrBadRequest
+		}
+		filter, err := locationFilter(c)
+		if err != nil {
+			return err
+		}
+
+		drivers, err := svc.FindKNearest(c.Context(), domain.NearQuery{
+			Longitude:   lon,
+			Latitude:    lat,
+			MaxDistance: maxDistance,
+			Limit:       limit,
+			Filter:      filter,
+		})
+		if err != nil {
+			return queryError(err)
+		}
+		return c.Status(http.StatusOK).JSON(drivers)
+	}
+}
+
+func FindWithinRadiusHandler(svc port.DriverLocationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		lon, err1 := strconv.ParseFloat(c.Query("lon"), 64)
+		lat, err2 := strconv.ParseFloat(c.Query("lat"), 64)
+		radius, err3 := strconv.ParseFloat(c.Query("radius"), 64)
+		if err1 != nil || err2 != nil || err3 != nil {
+			return fiber.ErrBadRequest
+		}
+		page, limit, err := pagination(c)
+		if err != nil {
+			return err
+		}
+		filter, err := locationFilter(c)
+		if err != nil {
+			return err
+		}
+
+		drivers, err := svc.FindWithinRadius(c.Context(), domain.NearQuery{
+			Longitude:   lon,
+			Latitude:    lat,
+			MaxDistance: radius,
+			Skip:        (page - 1) * limit,
+			Limit:       limit,
+			Filter:      filter,
+		})
+		if err != nil {
+			return queryError(err)
+		}
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"page":    page,
+			"limit":   limit,
+			"drivers": drivers,
+		})
+	}
+}
+
+func FindWithinBoxHandler(svc port.DriverLocationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		minLon, err1 := strconv.ParseFloat(c.Query("minLon"), 64)
+		minLat, err2 := strconv.ParseFloat(c.Query("minLat"), 64)
+		maxLon, err3 := strconv.ParseFloat(c.Query("maxLon"), 64)
+		maxLat, err4 := strconv.ParseFloat(c.Query("maxLat"), 64)
+		if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+			return fiber.ErrBadRequest
+		}
+		page, limit, err := pagination(c)
+		if err != nil {
+			return err
+		}
+		filter, err := locationFilter(c)
+		if err != nil {
+			return err
+		}
+
+		drivers, err := svc.FindWithin(c.Context(), domain.WithinQuery{
+			Box:    &domain.BoundingBox{MinLon: minLon, MinLat: minLat, MaxLon: maxLon, MaxLat: maxLat},
+			Skip:   (page - 1) * limit,
+			Limit:  limit,
+			Filter: filter,
+		})
+		if err != nil {
+			return queryError(err)
+		}
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"page":    page,
+			"limit":   limit,
+			"drivers": drivers,
+		})
+	}
+}
+
+func FindWithinPolygonHandler(svc port.DriverLocationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var polygon domain.GeoJSONPolygon
+		if err := c.BodyParser(&polygon); err != nil {
+			return fiber.ErrBadRequest
+		}
+		page, limit, err := pagination(c)
+		if err != nil {
+			return err
+		}
+		filter, err := locationFilter(c)
+		if err != nil {
+			return err
+		}
+
+		drivers, err := svc.FindWithin(c.Context(), domain.WithinQuery{
+			Polygon: &polygon,
+			Skip:    (page - 1) * limit,
+			Limit:   limit,
+			Filter:  filter,
+		})
+		if err != nil {
+			return queryError(err)
+		}
+		return c.Status(http.StatusOK).JSON(fiber.Map{
+			"page":    page,
+			"limit":   limit,
+			"drivers": drivers,
+		})
+	}
+}
+
+func TrajectoryHandler(svc port.DriverLocationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		driverID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+
+		to := time.Now().UTC()
+		if v := c.Query("to"); v != "" {
+			if to, err = time.Parse(time.RFC3339, v); err != nil {
+				return fiber.ErrBadRequest
+			}
+		}
+		from := to.Add(-defaultTrajectoryWindow)
+		if v := c.Query("from"); v != "" {
+			if from, err = time.Parse(time.RFC3339, v); err != nil {
+				return fiber.ErrBadRequest
+			}
+		}
+
+		points, err := svc.Trajectory(c.Context(), domain.HistoryQuery{DriverID: driverID, From: from, To: to})
+		if err != nil {
+			return queryError(err)
+		}
+
+		switch c.Query("format", "linestring") {
+		case "linestring":
+			// a LineString needs two positions; the feature collection
+			// format still returns a lone or missing ping
+			if len(points) < 2 {
+				return fiber.NewError(fiber.StatusNotFound, "fewer than two points recorded in the range")
+			}
+			return c.Status(http.StatusOK).JSON(domain.NewLineString(points))
+		case "featurecollection":
+			return c.Status(http.StatusOK).JSON(domain.NewFeatureCollection(points))
 		default:
-			return c.Status(http.StatusOK).JSON(driver)
+			return fiber.NewError(fiber.StatusBadRequest, "format must be linestring or featurecollection")
+		}
+	}
+}
+
+func pagination(c *fiber.Ctx) (page, limit int, err error) {
+	page, err1 := strconv.Atoi(c.Query("page", "1"))
+	limit, err2 := strconv.Atoi(c.Query("limit", strconv.Itoa(defaultPageSize)))
+	if err1 != nil || err2 != nil || page < 1 {
+		return 0, 0, fiber.ErrBadRequest
+	}
+	return page, limit, nil
+}
+
+func locationFilter(c *fiber.Ctx) (domain.LocationFilter, error) {
+	var filter domain.LocationFilter
+	if v := c.Query("maxAge"); v != "" {
+		maxAge, err := time.ParseDuration(v)
+		if err != nil {
+			return filter, fiber.NewError(fiber.StatusBadRequest, "maxAge must be a duration such as 5m")
 		}
+		filter.MaxAge = maxAge
+	}
+	for _, status := range splitList(c.Query("status")) {
+		filter.Statuses = append(filter.Statuses, domain.DriverStatus(status))
+	}
+	filter.VehicleTypes = splitList(c.Query("vehicleType"))
+	return filter, nil
+}
+
+// splitList parses a comma separated query value, skipping empty items.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// queryError maps service errors of the geo queries to HTTP errors.
+func queryError(err error) error {
+	switch {
+	case errors.Is(err, domain.ErrInvalidArgument):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return fiber.ErrNotFound
+	case errors.Is(err, circuitbreaker.ErrOpen):
+		return fiber.ErrServiceUnavailable
+	case errors.Is(err, circuitbreaker.ErrHalfOpen):
+		return fiber.ErrTooManyRequests
+	default:
+		return fiber.ErrInternalServerError
 	}
 }
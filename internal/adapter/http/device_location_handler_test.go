@@ -3,43 +3,112 @@ package http
 import (
 	"bytes"
 	"context"
-	"encoding/base64"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/envercigal/golang/internal/adapter/middleware"
 	"github.com/envercigal/golang/internal/core/domain"
 	"github.com/envercigal/golang/internal/core/port"
+	"github.com/envercigal/golang/internal/core/service"
 	circuitbreaker "github.com/envercigal/golang/pkg"
 	"io"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/stretchr/testify/assert"
 )
 
 // fake svc
 type fakeService struct {
-	createFn      func(ctx context.Context, dl *domain.DriverLocation) (*domain.DriverLocation, error) `json:"create_fn,omitempty"`
-	bulkCreateFn  func(ctx context.Context, r io.Reader) error                                         `json:"bulk_create_fn,omitempty"`
-	findNearestFn func(ctx context.Context, lon, lat float64) (*domain.DriverLocation, error)          `json:"find_nearest_fn,omitempty"`
+	createFn       func(ctx context.Context, dl *domain.DriverLocation) (*domain.DriverLocation, error)
+	bulkCreateFn   func(ctx context.Context, r io.Reader, opts domain.ImportOptions) (*domain.ImportReport, error)
+	startImportFn  func(ctx context.Context, r io.ReadCloser, opts domain.ImportOptions) (*domain.ImportJob, error)
+	streamFn       func(ctx context.Context, r io.Reader, ack func(*domain.StreamAck)) error
+	validateFn     func(ctx context.Context, r io.Reader, opts domain.ImportOptions) (*domain.ImportSummary, error)
+	importJobFn    func(ctx context.Context, id string) (*domain.ImportJob, error)
+	importReportFn func(ctx context.Context, id string) (*domain.ImportReport, error)
+	findNearestFn  func(ctx context.Context, lon, lat float64, f domain.LocationFilter) (*domain.DriverLocation, error)
+	findKNearestFn func(ctx context.Context, q domain.NearQuery) ([]*domain.DriverDistance, error)
+	findRadiusFn   func(ctx context.Context, q domain.NearQuery) ([]*domain.DriverDistance, error)
+	findWithinFn   func(ctx context.Context, q domain.WithinQuery) ([]*domain.DriverLocation, error)
+	trajectoryFn   func(ctx context.Context, q domain.HistoryQuery) ([]*domain.DriverLocation, error)
+	exportFn       func(ctx context.Context, q domain.ExportQuery) (port.LocationIterator, error)
 }
 
 func (f *fakeService) Create(ctx context.Context, dl *domain.DriverLocation) (*domain.DriverLocation, error) {
 	return f.createFn(ctx, dl)
 }
 
-func (f *fakeService) BulkCreate(ctx context.Context, r io.Reader) error {
-	return f.bulkCreateFn(ctx, r)
+func (f *fakeService) BulkCreate(ctx context.Context, r io.Reader, opts domain.ImportOptions) (*domain.ImportReport, error) {
+	return f.bulkCreateFn(ctx, r, opts)
 }
 
-func (f *fakeService) FindNearest(ctx context.Context, lon, lat float64) (*domain.DriverLocation, error) {
-	return f.findNearestFn(ctx, lon, lat)
+func (f *fakeService) StartImport(ctx context.Context, r io.ReadCloser, opts domain.ImportOptions) (*domain.ImportJob, error) {
+	return f.startImportFn(ctx, r, opts)
 }
 
+func (f *fakeService) StreamCreate(ctx context.Context, r io.Reader, ack func(*domain.StreamAck)) error {
+	return f.streamFn(ctx, r, ack)
+}
+
+func (f *fakeService) ValidateImport(ctx context.Context, r io.Reader, opts domain.ImportOptions) (*domain.ImportSummary, error) {
+	return f.validateFn(ctx, r, opts)
+}
+
+func (f *fakeService) Export(ctx context.Context, q domain.ExportQuery) (port.LocationIterator, error) {
+	return f.exportFn(ctx, q)
+}
+
+func (f *fakeService) ImportJob(ctx context.Context, id string) (*domain.ImportJob, error) {
+	return f.importJobFn(ctx, id)
+}
+
+func (f *fakeService) CancelImport(ctx context.Context, id string) (*domain.ImportJob, error) {
+	job, err := f.importJobFn(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	job.Status = domain.ImportCancelled
+	return job, nil
+}
+
+func (f *fakeService) ImportReport(ctx context.Context, id string) (*domain.ImportReport, error) {
+	return f.importReportFn(ctx, id)
+}
+
+func (f *fakeService) FindNearest(ctx context.Context, lon, lat float64, filter domain.LocationFilter) (*domain.DriverLocation, error) {
+	return f.findNearestFn(ctx, lon, lat, filter)
+}
+
+func (f *fakeService) FindKNearest(ctx context.Context, q domain.NearQuery) ([]*domain.DriverDistance, error) {
+	return f.findKNearestFn(ctx, q)
+}
+
+func (f *fakeService) FindWithinRadius(ctx context.Context, q domain.NearQuery) ([]*domain.DriverDistance, error) {
+	return f.findRadiusFn(ctx, q)
+}
+
+func (f *fakeService) FindWithin(ctx context.Context, q domain.WithinQuery) ([]*domain.DriverLocation, error) {
+	return f.findWithinFn(ctx, q)
+}
+
+func (f *fakeService) Trajectory(ctx context.Context, q domain.HistoryQuery) ([]*domain.DriverLocation, error) {
+	return f.trajectoryFn(ctx, q)
+}
+
+var testSecret = []byte("test-secret")
+
 func setupApp(svc port.DriverLocationService) *fiber.App {
 	app := fiber.New()
-	RegisterDriverRoutes(app, svc)
+	RegisterDriverRoutes(app, svc, middleware.AuthConfig{HMACSecrets: [][]byte{testSecret}})
 	return app
 }
 
@@ -65,12 +134,62 @@ func TestCreateHandler(t *testing.T) {
 	assert.True(t, called)
 }
 
+func TestCreateHandler_DriverRole(t *testing.T) {
+	svc := &fakeService{
+		createFn: func(ctx context.Context, dl *domain.DriverLocation) (*domain.DriverLocation, error) {
+			return dl, nil
+		},
+	}
+	app := setupApp(svc)
+	token := makeTokenWith(jwt.MapClaims{"roles": []string{middleware.RoleDriver}, "driver_id": 5})
+
+	for driverID, status := range map[int]int{5: http.StatusCreated, 6: http.StatusForbidden} {
+		body := fmt.Sprintf(`{"driver_id":%d,"location":{"type":"Point","coordinates":[29,41]}}`, driverID)
+		req := httptest.NewRequest("POST", "/drivers/", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, status, resp.StatusCode)
+	}
+}
+
+func TestRoutes_ForbiddenRoles(t *testing.T) {
+	app := setupApp(&fakeService{})
+	driver := makeTokenWith(jwt.MapClaims{"roles": []string{middleware.RoleDriver}, "driver_id": 5})
+	dispatcher := makeTokenWith(jwt.MapClaims{"scope": "read " + middleware.RoleDispatcher})
+
+	cases := []struct {
+		method, path, token string
+	}{
+		{"POST", "/drivers/import", dispatcher},
+		{"POST", "/drivers/import", driver},
+		{"GET", "/drivers/nearest?lon=29&lat=41", driver},
+		{"GET", "/drivers/5/trajectory", driver},
+		{"POST", "/drivers/", dispatcher},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.Header.Set("Authorization", "Bearer "+tc.token)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode, tc.method+" "+tc.path)
+
+		var body map[string]string
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.NotEmpty(t, body["error"])
+	}
+}
+
 func TestImportHandler(t *testing.T) {
-	done := false
+	var content []byte
+	var opts domain.ImportOptions
 	svc := &fakeService{
-		bulkCreateFn: func(ctx context.Context, r io.Reader) error {
-			done = true
-			return nil
+		startImportFn: func(ctx context.Context, r io.ReadCloser, o domain.ImportOptions) (*domain.ImportJob, error) {
+			defer r.Close()
+			content, _ = io.ReadAll(r)
+			opts = o
+			return &domain.ImportJob{ID: "job-1", Status: domain.ImportRunning}, nil
 		},
 	}
 	app := setupApp(svc)
@@ -79,7 +198,7 @@ func TestImportHandler(t *testing.T) {
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
 	part, _ := writer.CreateFormFile("file", "test.csv")
-	_, writeErr := part.Write([]byte("lat,lon\n41,29\n"))
+	_, writeErr := part.Write([]byte("driver_id,lat,lon\n1,41,29\n"))
 	if writeErr != nil {
 		return
 	}
@@ -94,19 +213,308 @@ func TestImportHandler(t *testing.T) {
 	req.Header.Set("Authorization", "Bearer "+token)
 	resp, err := app.Test(req)
 	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, "driver_id,lat,lon\n1,41,29\n", string(content))
+	assert.Equal(t, domain.FormatCSV, opts.Format)
+	sum := sha256.Sum256([]byte("driver_id,lat,lon\n1,41,29\n"))
+	assert.Equal(t, uploadKey(hex.EncodeToString(sum[:]), opts), opts.Key)
+
+	var job domain.ImportJob
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+	assert.Equal(t, "job-1", job.ID)
+}
+
+func TestImportHandler_DryRun(t *testing.T) {
+	var content []byte
+	svc := &fakeService{
+		startImportFn: func(ctx context.Context, r io.ReadCloser, o domain.ImportOptions) (*domain.ImportJob, error) {
+			t.Fatal("a dry run must not start an import")
+			return nil, nil
+		},
+		validateFn: func(ctx context.Context, r io.Reader, o domain.ImportOptions) (*domain.ImportSummary, error) {
+			content, _ = io.ReadAll(r)
+			return &domain.ImportSummary{Rows: 1, Valid: 1, DuplicateDriverIDs: []int{}}, nil
+		},
+	}
+	app := setupApp(svc)
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "drivers.csv")
+	_, _ = part.Write([]byte("driver_id,lat,lon\n1,41,29\n"))
+	_ = writer.Close()
+
+	req := httptest.NewRequest("POST", "/drivers/import?dryRun=true", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "driver_id,lat,lon\n1,41,29\n", string(content))
+
+	var summary domain.ImportSummary
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&summary))
+	assert.Equal(t, int64(1), summary.Valid)
+}
+
+func TestImportHandler_Gzip(t *testing.T) {
+	var content []byte
+	var opts domain.ImportOptions
+	svc := &fakeService{
+		startImportFn: func(ctx context.Context, r io.ReadCloser, o domain.ImportOptions) (*domain.ImportJob, error) {
+			defer r.Close()
+			content, _ = io.ReadAll(r)
+			opts = o
+			return &domain.ImportJob{ID: "job-1", Status: domain.ImportRunning}, nil
+		},
+	}
+	app := setupApp(svc)
+
+	geojson := `{"type":"FeatureCollection","features":[]}`
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("file", "drivers.geojson.gz")
+	_, _ = part.Write(gzipped(t, []byte(geojson)))
+	_ = writer.Close()
+
+	req := httptest.NewRequest("POST", "/drivers/import", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	assert.Equal(t, geojson, string(content))
+	assert.Equal(t, domain.FormatGeoJSON, opts.Format)
+}
+
+func TestImportHandler_CSVOptions(t *testing.T) {
+	var opts domain.ImportOptions
+	svc := &fakeService{
+		startImportFn: func(ctx context.Context, r io.ReadCloser, o domain.ImportOptions) (*domain.ImportJob, error) {
+			r.Close()
+			opts = o
+			return &domain.ImportJob{ID: "job-1", Status: domain.ImportRunning}, nil
+		},
+	}
+	app := setupApp(svc)
+
+	upload := func(query string) int {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("file", "drivers.tsv")
+		_, _ = part.Write([]byte("id\tlatitude\tlongitude\n1\t41\t29\n"))
+		_ = writer.Close()
+
+		req := httptest.NewRequest("POST", "/drivers/import?"+query, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		req.Header.Set("Authorization", "Bearer "+makeTestToken())
+		req.Header.Set("Idempotency-Key", "nightly-2025-03-01")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusAccepted, upload("delimiter=tab&columns=driver_id:id,lat:latitude,lon:longitude"))
+	assert.Equal(t, "nightly-2025-03-01", opts.Key)
+	assert.Equal(t, '\t', opts.Delimiter)
+	assert.Equal(t, map[string]string{"driver_id": "id", "lat": "latitude", "lon": "longitude"}, opts.Columns)
+
+	assert.Equal(t, http.StatusBadRequest, upload("delimiter=;;"))
+	assert.Equal(t, http.StatusBadRequest, upload("columns=latitude"))
+}
+
+func TestStreamDriversHandler(t *testing.T) {
+	var content []byte
+	svc := &fakeService{
+		streamFn: func(ctx context.Context, r io.Reader, ack func(*domain.StreamAck)) error {
+			content, _ = io.ReadAll(r)
+			ack(&domain.StreamAck{Read: 1, Accepted: 1})
+			ack(&domain.StreamAck{
+				Read:     2,
+				Accepted: 1,
+				Rejected: 1,
+				Errors:   []domain.RowError{{Row: 2, Reason: "missing driver_id"}},
+				Done:     true,
+			})
+			return nil
+		},
+	}
+	app := setupApp(svc)
+
+	stream := "{\"driver_id\":1,\"location\":{\"type\":\"Point\",\"coordinates\":[29,41]}}\n{}\n"
+	req := httptest.NewRequest("POST", "/drivers/stream", strings.NewReader(stream))
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
-	assert.True(t, done)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	assert.Equal(t, stream, string(content))
+
+	dec := json.NewDecoder(resp.Body)
+	var acks []domain.StreamAck
+	for dec.More() {
+		var ack domain.StreamAck
+		assert.NoError(t, dec.Decode(&ack))
+		acks = append(acks, ack)
+	}
+	assert.Len(t, acks, 2)
+	assert.Equal(t, int64(1), acks[0].Accepted)
+	assert.True(t, acks[1].Done)
+	assert.Equal(t, int64(1), acks[1].Rejected)
+}
+
+// TestStreamDriversHandler_AcksWhileStreaming runs over a real connection,
+// since the acknowledgements are written while the request is still read.
+func TestStreamDriversHandler_AcksWhileStreaming(t *testing.T) {
+	svc := service.NewDriverLocationService(&importRepo{}, circuitbreaker.New(5, time.Second), service.WithStreamFlushInterval(10*time.Millisecond))
+	app := fiber.New(fiber.Config{StreamRequestBody: true, DisableStartupMessage: true})
+	RegisterDriverRoutes(app, svc, middleware.AuthConfig{HMACSecrets: [][]byte{testSecret}})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	go app.Listener(ln)
+	defer app.Shutdown()
+
+	body, w := io.Pipe()
+	req, err := http.NewRequest("POST", "http://"+ln.Addr().String()+"/drivers/stream", body)
+	assert.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		responses <- resp
+	}()
+
+	_, err = io.WriteString(w, `{"driver_id":1,"location":{"type":"Point","coordinates":[29,41]}}`+"\n")
+	assert.NoError(t, err)
+	resp := <-responses
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	var ack domain.StreamAck
+	for ack.Accepted == 0 {
+		assert.NoError(t, dec.Decode(&ack))
+		assert.False(t, ack.Done, "the line is acknowledged before the stream ends")
+	}
+
+	assert.NoError(t, w.Close())
+	for !ack.Done {
+		assert.NoError(t, dec.Decode(&ack))
+	}
+	assert.Equal(t, int64(1), ack.Accepted)
+}
+
+func TestImportFormat(t *testing.T) {
+	assert.Equal(t, domain.FormatGeoJSON, importFormat("", "application/octet-stream", "drivers.geojson"))
+	assert.Equal(t, domain.FormatGeoJSON, importFormat("", "application/geo+json", "export"))
+	assert.Equal(t, domain.FormatCSV, importFormat("", "text/csv", "drivers.json"))
+	assert.Equal(t, domain.FormatCSV, importFormat("", "", "drivers.txt"))
+	assert.Equal(t, domain.FormatGeoJSON, importFormat("", "application/gzip", "drivers.geojson"))
+	assert.Equal(t, domain.FormatGeoJSON, importFormat("GeoJSON", "text/csv", "drivers.csv"))
+}
+
+func TestUploadKey(t *testing.T) {
+	opts := domain.ImportOptions{Format: domain.FormatCSV, Columns: map[string]string{"lat": "latitude", "lon": "longitude"}}
+	key := uploadKey("abc", opts)
+	assert.True(t, strings.HasPrefix(key, "sha256:"))
+	assert.Equal(t, key, uploadKey("abc", domain.ImportOptions{Format: domain.FormatCSV, Columns: map[string]string{"lon": "longitude", "lat": "latitude"}}))
+
+	for name, other := range map[string]domain.ImportOptions{
+		"no mapping":     {Format: domain.FormatCSV},
+		"other mapping":  {Format: domain.FormatCSV, Columns: map[string]string{"lat": "y", "lon": "x"}},
+		"delimiter":      {Format: domain.FormatCSV, Delimiter: ';', Columns: opts.Columns},
+		"other format":   {Format: domain.FormatNDJSON},
+		"other checksum": opts,
+	} {
+		checksum := "abc"
+		if name == "other checksum" {
+			checksum = "abd"
+		}
+		assert.NotEqual(t, key, uploadKey(checksum, other), name)
+	}
+}
+
+func TestImportJobHandler(t *testing.T) {
+	svc := &fakeService{
+		importJobFn: func(ctx context.Context, id string) (*domain.ImportJob, error) {
+			if id != "job-1" {
+				return nil, domain.ErrNotFound
+			}
+			return &domain.ImportJob{ID: id, Status: domain.ImportRunning, RowsRead: 10, Inserted: 8, Rejected: 2}, nil
+		},
+	}
+	app := setupApp(svc)
+
+	req := httptest.NewRequest("GET", "/drivers/import/job-1", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var job domain.ImportJob
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+	assert.Equal(t, int64(8), job.Inserted)
+
+	req = httptest.NewRequest("DELETE", "/drivers/import/job-1", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+	assert.Equal(t, domain.ImportCancelled, job.Status)
+
+	req = httptest.NewRequest("GET", "/drivers/import/unknown", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestImportReportHandler(t *testing.T) {
+	svc := &fakeService{
+		importReportFn: func(ctx context.Context, id string) (*domain.ImportReport, error) {
+			return &domain.ImportReport{
+				Accepted: 2,
+				Rejected: 1,
+				Errors:   []domain.RowError{{Row: 3, Reason: "latitude out of range: 999"}},
+			}, nil
+		},
+	}
+	app := setupApp(svc)
+
+	req := httptest.NewRequest("GET", "/drivers/import/job-1/report?format=csv", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("Content-Disposition"), "import-job-1-report.csv")
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "row,reason\n3,latitude out of range: 999\n", string(body))
+
+	req = httptest.NewRequest("GET", "/drivers/import/job-1/report", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	var report domain.ImportReport
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+	assert.Equal(t, int64(2), report.Accepted)
+	assert.Equal(t, int64(3), report.Errors[0].Row)
 }
 
 func makeTestToken() string {
-	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
-	payload := base64.RawURLEncoding.EncodeToString([]byte(`{"authenticated":true}`))
-	return header + "." + payload + "."
+	return makeTokenWith(jwt.MapClaims{"roles": []string{middleware.RoleAdmin}})
+}
+
+func makeTokenWith(claims jwt.MapClaims) string {
+	claims["sub"] = "test"
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	signed, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testSecret)
+	return signed
 }
 
 func TestFindNearestHandler(t *testing.T) {
 	svc := &fakeService{
-		findNearestFn: func(ctx context.Context, lon, lat float64) (*domain.DriverLocation, error) {
+		findNearestFn: func(ctx context.Context, lon, lat float64, f domain.LocationFilter) (*domain.DriverLocation, error) {
 			return &domain.DriverLocation{DriverID: 9}, nil
 		},
 	}
@@ -120,9 +528,29 @@ func TestFindNearestHandler(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
+func TestFindNearestHandler_PassesFilter(t *testing.T) {
+	var got domain.LocationFilter
+	svc := &fakeService{
+		findNearestFn: func(ctx context.Context, lon, lat float64, f domain.LocationFilter) (*domain.DriverLocation, error) {
+			got = f
+			return &domain.DriverLocation{DriverID: 9}, nil
+		},
+	}
+	app := setupApp(svc)
+
+	req := httptest.NewRequest("GET", "/drivers/nearest?lon=29&lat=41&status=available&vehicleType=car,van&maxAge=5m", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []domain.DriverStatus{domain.StatusAvailable}, got.Statuses)
+	assert.Equal(t, []string{"car", "van"}, got.VehicleTypes)
+	assert.Equal(t, 5*time.Minute, got.MaxAge)
+}
+
 func TestFindNearestHandler_Open(t *testing.T) {
 	svc := &fakeService{
-		findNearestFn: func(ctx context.Context, lon, lat float64) (*domain.DriverLocation, error) {
+		findNearestFn: func(ctx context.Context, lon, lat float64, f domain.LocationFilter) (*domain.DriverLocation, error) {
 			return nil, circuitbreaker.ErrOpen
 		},
 	}
@@ -138,7 +566,7 @@ func TestFindNearestHandler_Open(t *testing.T) {
 
 func TestFindNearestHandler_HalfOpen(t *testing.T) {
 	svc := &fakeService{
-		findNearestFn: func(ctx context.Context, lon, lat float64) (*domain.DriverLocation, error) {
+		findNearestFn: func(ctx context.Context, lon, lat float64, f domain.LocationFilter) (*domain.DriverLocation, error) {
 			return nil, circuitbreaker.ErrHalfOpen
 		},
 	}
@@ -151,3 +579,205 @@ func TestFindNearestHandler_HalfOpen(t *testing.T) {
 	resp, _ := app.Test(req, -1)
 	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
 }
+
+func TestFindKNearestHandler(t *testing.T) {
+	var got domain.NearQuery
+	svc := &fakeService{
+		findKNearestFn: func(ctx context.Context, q domain.NearQuery) ([]*domain.DriverDistance, error) {
+			got = q
+			return []*domain.DriverDistance{
+				{DriverLocation: domain.DriverLocation{DriverID: 1}, Distance: 12.5},
+				{DriverLocation: domain.DriverLocation{DriverID: 2}, Distance: 80},
+			}, nil
+		},
+	}
+	app := setupApp(svc)
+
+	req := httptest.NewRequest("GET", "/drivers/knearest?lon=29&lat=41&limit=2&maxDistance=500", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, domain.NearQuery{Longitude: 29, Latitude: 41, MaxDistance: 500, Limit: 2}, got)
+
+	var drivers []map[string]interface{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&drivers))
+	assert.Len(t, drivers, 2)
+	assert.Equal(t, 12.5, drivers[0]["distance"])
+	assert.Equal(t, float64(1), drivers[0]["driver_id"])
+}
+
+func TestFindKNearestHandler_MaxAge(t *testing.T) {
+	var got domain.NearQuery
+	svc := &fakeService{
+		findKNearestFn: func(ctx context.Context, q domain.NearQuery) ([]*domain.DriverDistance, error) {
+			got = q
+			return []*domain.DriverDistance{}, nil
+		},
+	}
+	app := setupApp(svc)
+
+	req := httptest.NewRequest("GET", "/drivers/knearest?lon=29&lat=41&maxAge=2m", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2*time.Minute, got.Filter.MaxAge)
+
+	req = httptest.NewRequest("GET", "/drivers/knearest?lon=29&lat=41&maxAge=soon", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestFindKNearestHandler_InvalidLimit(t *testing.T) {
+	app := setupApp(&fakeService{})
+
+	req := httptest.NewRequest("GET", "/drivers/knearest?lon=29&lat=41&limit=abc", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestFindWithinRadiusHandler(t *testing.T) {
+	var got domain.NearQuery
+	svc := &fakeService{
+		findRadiusFn: func(ctx context.Context, q domain.NearQuery) ([]*domain.DriverDistance, error) {
+			got = q
+			return []*domain.DriverDistance{{DriverLocation: domain.DriverLocation{DriverID: 3}, Distance: 150}}, nil
+		},
+	}
+	app := setupApp(svc)
+
+	req := httptest.NewRequest("GET", "/drivers/within?lon=29&lat=41&radius=2000&page=3&limit=20", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, domain.NearQuery{Longitude: 29, Latitude: 41, MaxDistance: 2000, Skip: 40, Limit: 20}, got)
+}
+
+func TestFindWithinRadiusHandler_StatusAndVehicleFilter(t *testing.T) {
+	var got domain.NearQuery
+	svc := &fakeService{
+		findRadiusFn: func(ctx context.Context, q domain.NearQuery) ([]*domain.DriverDistance, error) {
+			got = q
+			return []*domain.DriverDistance{}, nil
+		},
+	}
+	app := setupApp(svc)
+
+	req := httptest.NewRequest("GET", "/drivers/within?lon=29&lat=41&radius=2000&status=available,break&vehicleType=car", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, []domain.DriverStatus{domain.StatusAvailable, domain.StatusBreak}, got.Filter.Statuses)
+	assert.Equal(t, []string{"car"}, got.Filter.VehicleTypes)
+}
+
+func TestFindWithinRadiusHandler_MissingRadius(t *testing.T) {
+	app := setupApp(&fakeService{})
+
+	req := httptest.NewRequest("GET", "/drivers/within?lon=29&lat=41", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestFindWithinBoxHandler(t *testing.T) {
+	var got domain.WithinQuery
+	svc := &fakeService{
+		findWithinFn: func(ctx context.Context, q domain.WithinQuery) ([]*domain.DriverLocation, error) {
+			got = q
+			return []*domain.DriverLocation{{DriverID: 4}}, nil
+		},
+	}
+	app := setupApp(svc)
+
+	req := httptest.NewRequest("GET", "/drivers/box?minLon=28.9&minLat=40.9&maxLon=29.1&maxLat=41.1", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, &domain.BoundingBox{MinLon: 28.9, MinLat: 40.9, MaxLon: 29.1, MaxLat: 41.1}, got.Box)
+	assert.Equal(t, defaultPageSize, got.Limit)
+}
+
+func TestFindWithinPolygonHandler_Invalid(t *testing.T) {
+	svc := &fakeService{
+		findWithinFn: func(ctx context.Context, q domain.WithinQuery) ([]*domain.DriverLocation, error) {
+			return nil, fmt.Errorf("%w: ring 0 is not closed", domain.ErrInvalidArgument)
+		},
+	}
+	app := setupApp(svc)
+
+	body := `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1]]]}`
+	req := httptest.NewRequest("POST", "/drivers/polygon", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestTrajectoryHandler(t *testing.T) {
+	var got domain.HistoryQuery
+	svc := &fakeService{
+		trajectoryFn: func(ctx context.Context, q domain.HistoryQuery) ([]*domain.DriverLocation, error) {
+			got = q
+			return []*domain.DriverLocation{
+				{DriverID: 7, Location: domain.GeoJSONPoint{Type: "Point", Coordinates: []float64{29, 41}}},
+				{DriverID: 7, Location: domain.GeoJSONPoint{Type: "Point", Coordinates: []float64{29.1, 41.1}}},
+			}, nil
+		},
+	}
+	app := setupApp(svc)
+
+	req := httptest.NewRequest("GET", "/drivers/7/trajectory?from=2025-01-01T00:00:00Z&to=2025-01-02T00:00:00Z", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 7, got.DriverID)
+	assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), got.From)
+
+	var line domain.GeoJSONLineString
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&line))
+	assert.Equal(t, "LineString", line.Type)
+	assert.Equal(t, [][]float64{{29, 41}, {29.1, 41.1}}, line.Coordinates)
+}
+
+func TestTrajectoryHandler_FewerThanTwoPoints(t *testing.T) {
+	for _, points := range [][]*domain.DriverLocation{
+		{},
+		{{DriverID: 7, Location: domain.GeoJSONPoint{Type: "Point", Coordinates: []float64{29, 41}}}},
+	} {
+		svc := &fakeService{
+			trajectoryFn: func(ctx context.Context, q domain.HistoryQuery) ([]*domain.DriverLocation, error) {
+				return points, nil
+			},
+		}
+		app := setupApp(svc)
+
+		req := httptest.NewRequest("GET", "/drivers/7/trajectory", nil)
+		req.Header.Set("Authorization", "Bearer "+makeTestToken())
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+		req = httptest.NewRequest("GET", "/drivers/7/trajectory?format=featurecollection", nil)
+		req.Header.Set("Authorization", "Bearer "+makeTestToken())
+		resp, err = app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var fc domain.GeoJSONFeatureCollection
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(&fc))
+		assert.Equal(t, "FeatureCollection", fc.Type)
+		assert.Len(t, fc.Features, len(points))
+	}
+}
@@ -0,0 +1,190 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/envercigal/golang/internal/core/domain"
+	"github.com/envercigal/golang/internal/core/port"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"log"
+	"strconv"
+	"time"
+)
+
+// exportHeader matches the columns the CSV import reads, so exports can be
+// imported again as is.
+var exportHeader = []string{"driver_id", "lat", "lon", "timestamp", "status", "vehicle_type"}
+
+// ExportDriversHandler streams the current positions or the history in CSV,
+// GeoJSON or NDJSON. The locations are written as they are read from the
+// database, so the size of an export does not affect memory use.
+func ExportDriversHandler(svc port.DriverLocationService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		format := domain.ImportFormat(c.Query("format", string(domain.FormatCSV)))
+		contentType, ext, ok := exportContentType(format)
+		if !ok {
+			return fiber.NewError(fiber.StatusBadRequest, "format must be csv, geojson or ndjson")
+		}
+		query, err := exportQuery(c)
+		if err != nil {
+			return err
+		}
+
+		it, err := svc.Export(c.UserContext(), query)
+		if err != nil {
+			return queryError(err)
+		}
+
+		ctx := c.UserContext()
+		c.Set(fiber.HeaderContentType, contentType)
+		c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="drivers-%s.%s"`, query.Source, ext))
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer it.Close(ctx)
+			if err := writeExport(ctx, w, format, it); err != nil {
+				log.Printf("export error: %v", err)
+			}
+			if err := w.Flush(); err != nil {
+				log.Printf("export error: %v", err)
+			}
+		})
+		return nil
+	}
+}
+
+func exportContentType(format domain.ImportFormat) (contentType, ext string, ok bool) {
+	switch format {
+	case domain.FormatCSV:
+		return "text/csv", "csv", true
+	case domain.FormatGeoJSON:
+		return "application/geo+json", "geojson", true
+	case domain.FormatNDJSON:
+		return "application/x-ndjson", "ndjson", true
+	default:
+		return "", "", false
+	}
+}
+
+// exportQuery reads the source, the RFC 3339 time range, the bounding box and
+// the status and vehicle type filters of an export.
+func exportQuery(c *fiber.Ctx) (domain.ExportQuery, error) {
+	query := domain.ExportQuery{
+		Source:       domain.LocationSource(c.Query("source", string(domain.SourceCurrent))),
+		VehicleTypes: splitList(c.Query("vehicleType")),
+	}
+	for _, status := range splitList(c.Query("status")) {
+		query.Statuses = append(query.Statuses, domain.DriverStatus(status))
+	}
+
+	var err error
+	if v := c.Query("from"); v != "" {
+		if query.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return query, fiber.NewError(fiber.StatusBadRequest, "from must be an RFC 3339 time")
+		}
+	}
+	if v := c.Query("to"); v != "" {
+		if query.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return query, fiber.NewError(fiber.StatusBadRequest, "to must be an RFC 3339 time")
+		}
+	}
+
+	bounds := []string{c.Query("minLon"), c.Query("minLat"), c.Query("maxLon"), c.Query("maxLat")}
+	if bounds[0] == "" && bounds[1] == "" && bounds[2] == "" && bounds[3] == "" {
+		return query, nil
+	}
+	var box [4]float64
+	for i, v := range bounds {
+		if box[i], err = strconv.ParseFloat(v, 64); err != nil {
+			return query, fiber.NewError(fiber.StatusBadRequest, "minLon, minLat, maxLon and maxLat must all be numbers")
+		}
+	}
+	query.Box = &domain.BoundingBox{MinLon: box[0], MinLat: box[1], MaxLon: box[2], MaxLat: box[3]}
+	return query, nil
+}
+
+func writeExport(ctx context.Context, w io.Writer, format domain.ImportFormat, it port.LocationIterator) error {
+	switch format {
+	case domain.FormatGeoJSON:
+		return writeGeoJSON(ctx, w, it)
+	case domain.FormatNDJSON:
+		return writeNDJSON(ctx, w, it)
+	default:
+		return writeCSV(ctx, w, it)
+	}
+}
+
+// each calls fn for every location of the iterator.
+func each(ctx context.Context, it port.LocationIterator, fn func(*domain.DriverLocation) error) error {
+	for {
+		dl, err := it.Next(ctx)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(dl); err != nil {
+			return err
+		}
+	}
+}
+
+func writeCSV(ctx context.Context, w io.Writer, it port.LocationIterator) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportHeader); err != nil {
+		return err
+	}
+	err := each(ctx, it, func(dl *domain.DriverLocation) error {
+		return cw.Write([]string{
+			strconv.Itoa(dl.DriverID),
+			strconv.FormatFloat(dl.Location.Coordinates[1], 'f', -1, 64),
+			strconv.FormatFloat(dl.Location.Coordinates[0], 'f', -1, 64),
+			dl.Updated.UTC().Format(time.RFC3339Nano),
+			string(dl.Status),
+			dl.VehicleType,
+		})
+	})
+	cw.Flush()
+	if err != nil {
+		return err
+	}
+	return cw.Error()
+}
+
+// writeGeoJSON writes a FeatureCollection one feature at a time.
+func writeGeoJSON(ctx context.Context, w io.Writer, it port.LocationIterator) error {
+	if _, err := io.WriteString(w, `{"type":"FeatureCollection","features":[`); err != nil {
+		return err
+	}
+	first := true
+	err := each(ctx, it, func(dl *domain.DriverLocation) error {
+		feature, err := json.Marshal(domain.NewPointFeature(dl))
+		if err != nil {
+			return err
+		}
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		_, err = w.Write(feature)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "]}\n")
+	return err
+}
+
+func writeNDJSON(ctx context.Context, w io.Writer, it port.LocationIterator) error {
+	enc := json.NewEncoder(w)
+	return each(ctx, it, func(dl *domain.DriverLocation) error {
+		return enc.Encode(dl)
+	})
+}
@@ -0,0 +1,189 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/envercigal/golang/internal/core/domain"
+	"github.com/envercigal/golang/internal/core/port"
+	"github.com/envercigal/golang/internal/core/service"
+	circuitbreaker "github.com/envercigal/golang/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+type sliceIterator struct {
+	locations []*domain.DriverLocation
+	closed    bool
+}
+
+func (s *sliceIterator) Next(ctx context.Context) (*domain.DriverLocation, error) {
+	if len(s.locations) == 0 {
+		return nil, io.EOF
+	}
+	dl := s.locations[0]
+	s.locations = s.locations[1:]
+	return dl, nil
+}
+
+func (s *sliceIterator) Close(ctx context.Context) error {
+	s.closed = true
+	return nil
+}
+
+// importRepo records the locations an import writes.
+type importRepo struct {
+	port.DriverLocationRepository
+	stored []*domain.DriverLocation
+}
+
+func (r *importRepo) BulkCreate(ctx context.Context, dls []*domain.DriverLocation) (int, error) {
+	r.stored = append(r.stored, dls...)
+	return 0, nil
+}
+
+func exportedLocations() []*domain.DriverLocation {
+	return []*domain.DriverLocation{
+		{
+			DriverID:    7,
+			Location:    domain.GeoJSONPoint{Type: "Point", Coordinates: []float64{29.0123456, 41.0654321}},
+			Status:      domain.StatusOnTrip,
+			VehicleType: "van",
+			Updated:     time.Date(2025, 3, 1, 10, 0, 0, 123000000, time.UTC),
+		},
+		{
+			DriverID: 8,
+			Location: domain.GeoJSONPoint{Type: "Point", Coordinates: []float64{-0.5, 51.5}},
+			Status:   domain.StatusAvailable,
+			Updated:  time.Date(2025, 3, 1, 11, 0, 0, 0, time.UTC),
+		},
+	}
+}
+
+func TestExportDriversHandler(t *testing.T) {
+	var query domain.ExportQuery
+	it := &sliceIterator{locations: exportedLocations()}
+	svc := &fakeService{
+		exportFn: func(ctx context.Context, q domain.ExportQuery) (port.LocationIterator, error) {
+			query = q
+			return it, nil
+		},
+	}
+	app := setupApp(svc)
+
+	req := httptest.NewRequest("GET", "/drivers/export?source=history&from=2025-03-01T00:00:00Z&minLon=-1&minLat=50&maxLon=30&maxLat=42&status=on_trip,available", nil)
+	req.Header.Set("Authorization", "Bearer "+makeTestToken())
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/csv", resp.Header.Get("Content-Type"))
+	assert.Equal(t, `attachment; filename="drivers-history.csv"`, resp.Header.Get("Content-Disposition"))
+
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(t, "driver_id,lat,lon,timestamp,status,vehicle_type\n"+
+		"7,41.0654321,29.0123456,2025-03-01T10:00:00.123Z,on_trip,van\n"+
+		"8,51.5,-0.5,2025-03-01T11:00:00Z,available,\n", string(body))
+	assert.True(t, it.closed)
+
+	assert.Equal(t, domain.SourceHistory, query.Source)
+	assert.Equal(t, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), query.From)
+	assert.True(t, query.To.IsZero())
+	assert.Equal(t, &domain.BoundingBox{MinLon: -1, MinLat: 50, MaxLon: 30, MaxLat: 42}, query.Box)
+	assert.Equal(t, []domain.DriverStatus{domain.StatusOnTrip, domain.StatusAvailable}, query.Statuses)
+}
+
+func TestExportDriversHandler_InvalidQuery(t *testing.T) {
+	app := setupApp(&fakeService{})
+	for _, query := range []string{"format=xml", "from=yesterday", "minLon=1"} {
+		req := httptest.NewRequest("GET", "/drivers/export?"+query, nil)
+		req.Header.Set("Authorization", "Bearer "+makeTestToken())
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+	}
+}
+
+func TestExport_RoundTrip(t *testing.T) {
+	for _, format := range []domain.ImportFormat{domain.FormatCSV, domain.FormatGeoJSON, domain.FormatNDJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			it := &sliceIterator{locations: exportedLocations()}
+			assert.NoError(t, writeExport(context.Background(), &buf, format, it))
+
+			repo := &importRepo{}
+			svc := service.NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+			report, err := svc.BulkCreate(context.Background(), &buf, domain.ImportOptions{Format: format})
+			assert.NoError(t, err)
+			assert.Equal(t, int64(2), report.Accepted)
+			assert.Equal(t, exportedLocations(), repo.stored)
+		})
+	}
+}
+
+// TestExport_ImportRoundTrip uploads each export without a format parameter,
+// so the import has to detect it from the file name or the content type.
+func TestExport_ImportRoundTrip(t *testing.T) {
+	for _, format := range []domain.ImportFormat{domain.FormatCSV, domain.FormatGeoJSON, domain.FormatNDJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			repo := &importRepo{}
+			imports := service.NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+			svc := &fakeService{
+				exportFn: func(ctx context.Context, q domain.ExportQuery) (port.LocationIterator, error) {
+					return &sliceIterator{locations: exportedLocations()}, nil
+				},
+				startImportFn: func(ctx context.Context, r io.ReadCloser, o domain.ImportOptions) (*domain.ImportJob, error) {
+					defer r.Close()
+					assert.Equal(t, format, o.Format)
+					_, err := imports.BulkCreate(ctx, r, o)
+					assert.NoError(t, err)
+					return &domain.ImportJob{ID: "job-1", Status: domain.ImportRunning}, nil
+				},
+			}
+			app := setupApp(svc)
+
+			req := httptest.NewRequest("GET", "/drivers/export?format="+string(format), nil)
+			req.Header.Set("Authorization", "Bearer "+makeTestToken())
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			exported, _ := io.ReadAll(resp.Body)
+			contentType := resp.Header.Get("Content-Type")
+			_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition"))
+			assert.NoError(t, err)
+
+			upload := func(filename, contentType string) {
+				body := &bytes.Buffer{}
+				writer := multipart.NewWriter(body)
+				header := textproto.MIMEHeader{}
+				header.Set("Content-Disposition", `form-data; name="file"; filename="`+filename+`"`)
+				header.Set("Content-Type", contentType)
+				part, _ := writer.CreatePart(header)
+				_, _ = part.Write(exported)
+				_ = writer.Close()
+
+				req := httptest.NewRequest("POST", "/drivers/import", body)
+				req.Header.Set("Content-Type", writer.FormDataContentType())
+				req.Header.Set("Authorization", "Bearer "+makeTestToken())
+				resp, err := app.Test(req)
+				assert.NoError(t, err)
+				assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+			}
+
+			// detected from the file name
+			upload(params["filename"], "application/octet-stream")
+			assert.Equal(t, exportedLocations(), repo.stored)
+
+			// detected from the content type
+			repo.stored = nil
+			upload("drivers", contentType)
+			assert.Equal(t, exportedLocations(), repo.stored)
+		})
+	}
+}
@@ -3,13 +3,37 @@ package http
 import (
 	"github.com/envercigal/golang/internal/adapter/middleware"
 	"github.com/envercigal/golang/internal/core/port"
+	circuitbreaker "github.com/envercigal/golang/pkg"
 	"github.com/gofiber/fiber/v2"
 )
 
-func RegisterDriverRoutes(app *fiber.App, svc port.DriverLocationService) {
-	grp := app.Group("/drivers", middleware.RequireAuthenticated())
+func RegisterDriverRoutes(app *fiber.App, svc port.DriverLocationService, auth middleware.AuthConfig) {
+	grp := app.Group("/drivers", middleware.RequireAuthenticated(auth))
 
-	grp.Post("/", CreateDriverHandler(svc))
-	grp.Post("/import", ImportDriversHandler(svc))
-	grp.Get("/nearest", FindNearestHandler(svc))
+	drivers := middleware.RequireRole(middleware.RoleDriver, middleware.RoleAdmin)
+	dispatchers := middleware.RequireRole(middleware.RoleDispatcher, middleware.RoleAdmin)
+	admins := middleware.RequireRole(middleware.RoleAdmin)
+
+	grp.Post("/", drivers, middleware.LimitBody(), CreateDriverHandler(svc))
+	grp.Post("/import", admins, ImportDriversHandler(svc))
+	grp.Post("/stream", admins, StreamDriversHandler(svc))
+	grp.Get("/import/:id", admins, ImportJobHandler(svc))
+	grp.Delete("/import/:id", admins, CancelImportHandler(svc))
+	grp.Get("/import/:id/report", admins, ImportReportHandler(svc))
+	grp.Get("/export", admins, ExportDriversHandler(svc))
+	grp.Get("/nearest", dispatchers, FindNearestHandler(svc))
+	grp.Get("/knearest", dispatchers, FindKNearestHandler(svc))
+	grp.Get("/within", dispatchers, FindWithinRadiusHandler(svc))
+	grp.Get("/box", dispatchers, FindWithinBoxHandler(svc))
+	grp.Post("/polygon", dispatchers, middleware.LimitBody(), FindWithinPolygonHandler(svc))
+	grp.Get("/:id/trajectory", dispatchers, TrajectoryHandler(svc))
+}
+
+// RegisterAdminRoutes exposes the circuit breakers to admins and their
+// metrics to Prometheus.
+func RegisterAdminRoutes(app *fiber.App, breakers *circuitbreaker.Registry, auth middleware.AuthConfig) {
+	app.Get("/metrics", MetricsHandler(breakers))
+
+	grp := app.Group("/admin", middleware.RequireAuthenticated(auth), middleware.RequireRole(middleware.RoleAdmin))
+	grp.Get("/breakers", BreakersHandler(breakers))
 }
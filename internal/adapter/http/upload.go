@@ -0,0 +1,129 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/envercigal/golang/internal/core/domain"
+	"github.com/gofiber/fiber/v2"
+	"io"
+	"maps"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// tempFile is a spooled upload that is removed from disk when closed.
+type tempFile struct {
+	*os.File
+}
+
+func (f tempFile) Close() error {
+	err := f.File.Close()
+	if rmErr := os.Remove(f.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// spoolUpload copies a multipart upload into a temporary file, because
+// fasthttp releases the request's form files once the handler returns while
+// background imports keep reading. It returns the SHA-256 checksum of the
+// upload as well.
+func spoolUpload(fh *multipart.FileHeader) (tempFile, string, error) {
+	src, err := fh.Open()
+	if err != nil {
+		return tempFile{}, "", err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp("", "driver-import-*")
+	if err != nil {
+		return tempFile{}, "", err
+	}
+	f := tempFile{dst}
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(dst, hash), src); err != nil {
+		f.Close()
+		return tempFile{}, "", err
+	}
+	if _, err := dst.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return tempFile{}, "", err
+	}
+	return f, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// uploadKey identifies an upload by its checksum and the options it is parsed
+// with, so a file uploaded again with a corrected format, delimiter or column
+// mapping is parsed again rather than matched to the earlier import.
+func uploadKey(checksum string, opts domain.ImportOptions) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s\n%s\n%q\n", checksum, opts.Format, opts.Delimiter)
+	for _, column := range slices.Sorted(maps.Keys(opts.Columns)) {
+		fmt.Fprintf(hash, "%s=%s\n", column, opts.Columns[column])
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// importFormat picks the parser of an upload: an explicit format wins, then
+// the content type of the file part, then the extension of the file name.
+// CSV is the default.
+func importFormat(explicit, contentType, name string) domain.ImportFormat {
+	if explicit != "" {
+		return domain.ImportFormat(strings.ToLower(explicit))
+	}
+
+	contentType, _, _ = mime.ParseMediaType(contentType)
+	switch contentType {
+	case "application/geo+json", "application/json":
+		return domain.FormatGeoJSON
+	case "application/x-ndjson":
+		return domain.FormatNDJSON
+	case "text/csv":
+		return domain.FormatCSV
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".geojson", ".json":
+		return domain.FormatGeoJSON
+	case ".ndjson":
+		return domain.FormatNDJSON
+	default:
+		return domain.FormatCSV
+	}
+}
+
+// importOptions reads the parser options of an upload from the query. The
+// delimiter is a single character or "tab", and columns overrides headers as
+// a list of column:header pairs such as "lat:latitude,lon:longitude".
+func importOptions(c *fiber.Ctx, contentType, name string) (domain.ImportOptions, error) {
+	opts := domain.ImportOptions{Format: importFormat(c.Query("format"), contentType, name)}
+
+	switch delimiter := c.Query("delimiter"); {
+	case delimiter == "":
+	case strings.EqualFold(delimiter, "tab"):
+		opts.Delimiter = '\t'
+	case utf8.RuneCountInString(delimiter) == 1:
+		opts.Delimiter, _ = utf8.DecodeRuneInString(delimiter)
+	default:
+		return opts, fmt.Errorf("delimiter must be a single character, got %q", delimiter)
+	}
+
+	for _, pair := range splitList(c.Query("columns")) {
+		column, header, ok := strings.Cut(pair, ":")
+		if !ok {
+			return opts, fmt.Errorf("invalid column mapping %q, expected column:header", pair)
+		}
+		if opts.Columns == nil {
+			opts.Columns = map[string]string{}
+		}
+		opts.Columns[strings.TrimSpace(column)] = strings.TrimSpace(header)
+	}
+	return opts, nil
+}
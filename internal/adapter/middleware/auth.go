@@ -1,32 +1,162 @@
 package middleware
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
 )
 
-func RequireAuthenticated() fiber.Handler {
+const claimsKey = "claims"
+
+var allowedAlgorithms = []string{"HS256", "RS256", "ES256"}
+
+// AuthConfig holds the keys and expected claims used to verify bearer tokens.
+// Every configured key of the token's algorithm is tried, so keys can be
+// rotated by configuring the old and the new key side by side.
+type AuthConfig struct {
+	HMACSecrets     [][]byte
+	RSAPublicKeys   []*rsa.PublicKey
+	ECDSAPublicKeys []*ecdsa.PublicKey
+	JWKS            *JWKS  // keys selected by the token's "kid" header
+	Issuer          string // required "iss" claim, ignored when empty
+	Audience        string // required "aud" claim, ignored when empty
+}
+
+// LoadRSAPublicKey reads a PEM encoded RSA public key or certificate.
+func LoadRSAPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.ParseRSAPublicKeyFromPEM(data)
+}
+
+// LoadECDSAPublicKey reads a PEM encoded ECDSA public key or certificate.
+func LoadECDSAPublicKey(path string) (*ecdsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.ParseECPublicKeyFromPEM(data)
+}
+
+func RequireAuthenticated(cfg AuthConfig) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
 		if authHeader == "" {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "missing Authorization header"})
 		}
 
-		tokenString := authHeader[len("Bearer "):]
-		token, _, err := new(jwt.Parser).ParseUnverified(tokenString, jwt.MapClaims{})
+		scheme, tokenString, ok := strings.Cut(authHeader, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(tokenString) == "" {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid Authorization header"})
+		}
+
+		claims, err := cfg.verify(c.UserContext(), strings.TrimSpace(tokenString))
 		if err != nil {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid token format"})
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "invalid token claims"})
+		c.Locals(claimsKey, claims)
+		return c.Next()
+	}
+}
+
+// Claims returns the verified claims of the request, or nil when the route is
+// not behind RequireAuthenticated.
+func Claims(c *fiber.Ctx) jwt.MapClaims {
+	claims, _ := c.Locals(claimsKey).(jwt.MapClaims)
+	return claims
+}
+
+func (cfg AuthConfig) verify(ctx context.Context, tokenString string) (jwt.MapClaims, error) {
+	parser := jwt.NewParser(jwt.WithValidMethods(allowedAlgorithms))
+
+	unverified, _, err := parser.ParseUnverified(tokenString, jwt.MapClaims{})
+	if err != nil {
+		return nil, errors.New("invalid token format")
+	}
+	alg, _ := unverified.Header["alg"].(string)
+	kid, _ := unverified.Header["kid"].(string)
+
+	keys := cfg.keys(ctx, alg, kid)
+	if len(keys) == 0 {
+		if cfg.JWKS != nil && kid != "" && slices.Contains(allowedAlgorithms, alg) {
+			return nil, fmt.Errorf("signing key %q not found", kid)
 		}
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
 
-		if authVal, exists := claims["authenticated"]; !exists || authVal != true {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "authentication required"})
+	var claims jwt.MapClaims
+	for _, key := range keys {
+		claims = jwt.MapClaims{}
+		_, err = parser.ParseWithClaims(tokenString, claims, func(*jwt.Token) (interface{}, error) {
+			return key, nil
+		})
+		if !errors.Is(err, jwt.ErrSignatureInvalid) {
+			break
 		}
+	}
+	switch {
+	case errors.Is(err, jwt.ErrSignatureInvalid):
+		return nil, errors.New("invalid token signature")
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return nil, errors.New("token is expired")
+	case errors.Is(err, jwt.ErrTokenNotValidYet):
+		return nil, errors.New("token is not valid yet")
+	case err != nil:
+		return nil, errors.New("invalid token")
+	}
 
-		return c.Next()
+	now := time.Now().Unix()
+	if !claims.VerifyExpiresAt(now, true) {
+		return nil, errors.New("token has no expiry")
+	}
+	if cfg.Issuer != "" && !claims.VerifyIssuer(cfg.Issuer, true) {
+		return nil, errors.New("invalid token issuer")
+	}
+	if cfg.Audience != "" && !claims.VerifyAudience(cfg.Audience, true) {
+		return nil, errors.New("invalid token audience")
+	}
+	return claims, nil
+}
+
+// keys returns the verification keys for the algorithm. A JWKS key matching
+// the token's key id is tried first, followed by the statically configured
+// keys.
+func (cfg AuthConfig) keys(ctx context.Context, alg, kid string) []interface{} {
+	if !slices.Contains(allowedAlgorithms, alg) {
+		return nil
+	}
+
+	var keys []interface{}
+	if cfg.JWKS != nil && kid != "" {
+		if key, ok := cfg.JWKS.Key(ctx, kid); ok {
+			keys = append(keys, key)
+		}
+	}
+	switch alg {
+	case "HS256":
+		for _, k := range cfg.HMACSecrets {
+			keys = append(keys, k)
+		}
+	case "RS256":
+		for _, k := range cfg.RSAPublicKeys {
+			keys = append(keys, k)
+		}
+	case "ES256":
+		for _, k := range cfg.ECDSAPublicKeys {
+			keys = append(keys, k)
+		}
 	}
+	return keys
 }
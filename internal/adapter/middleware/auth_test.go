@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+var testSecret = []byte("test-secret")
+
+func setupApp(cfg AuthConfig) *fiber.App {
+	app := fiber.New()
+	app.Get("/", RequireAuthenticated(cfg), func(c *fiber.Ctx) error {
+		return c.JSON(Claims(c))
+	})
+	return app
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	signed, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	assert.NoError(t, err)
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub": "user-1",
+		"iss": "https://issuer.test",
+		"aud": "driver-location",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func doRequest(t *testing.T, app *fiber.App, authHeader string) int {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/", nil)
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	resp, err := app.Test(req)
+	assert.NoError(t, err)
+	return resp.StatusCode
+}
+
+func TestRequireAuthenticated_HS256(t *testing.T) {
+	app := setupApp(AuthConfig{
+		HMACSecrets: [][]byte{[]byte("old-secret"), testSecret},
+		Issuer:      "https://issuer.test",
+		Audience:    "driver-location",
+	})
+
+	token := sign(t, jwt.SigningMethodHS256, testSecret, validClaims())
+	assert.Equal(t, http.StatusOK, doRequest(t, app, "Bearer "+token))
+}
+
+func TestRequireAuthenticated_RS256AndES256FromFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	rsaPath := writePublicKey(t, dir, "rsa.pem", &rsaKey.PublicKey)
+	ecPath := writePublicKey(t, dir, "ec.pem", &ecKey.PublicKey)
+
+	rsaPub, err := LoadRSAPublicKey(rsaPath)
+	assert.NoError(t, err)
+	ecPub, err := LoadECDSAPublicKey(ecPath)
+	assert.NoError(t, err)
+
+	app := setupApp(AuthConfig{RSAPublicKeys: []*rsa.PublicKey{rsaPub}, ECDSAPublicKeys: []*ecdsa.PublicKey{ecPub}})
+
+	assert.Equal(t, http.StatusOK, doRequest(t, app, "Bearer "+sign(t, jwt.SigningMethodRS256, rsaKey, validClaims())))
+	assert.Equal(t, http.StatusOK, doRequest(t, app, "Bearer "+sign(t, jwt.SigningMethodES256, ecKey, validClaims())))
+}
+
+func TestRequireAuthenticated_Rejects(t *testing.T) {
+	app := setupApp(AuthConfig{
+		HMACSecrets: [][]byte{testSecret},
+		Issuer:      "https://issuer.test",
+		Audience:    "driver-location",
+	})
+
+	expired := validClaims()
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	notYetValid := validClaims()
+	notYetValid["nbf"] = time.Now().Add(time.Hour).Unix()
+	noExpiry := validClaims()
+	delete(noExpiry, "exp")
+	wrongIssuer := validClaims()
+	wrongIssuer["iss"] = "https://evil.test"
+	wrongAudience := validClaims()
+	wrongAudience["aud"] = "other-service"
+
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"user-1"}`)) + "."
+
+	cases := map[string]string{
+		"missing header":   "",
+		"missing bearer":   sign(t, jwt.SigningMethodHS256, testSecret, validClaims()),
+		"short header":     "Bear",
+		"empty token":      "Bearer ",
+		"alg none":         "Bearer " + none,
+		"wrong secret":     "Bearer " + sign(t, jwt.SigningMethodHS256, []byte("other"), validClaims()),
+		"expired":          "Bearer " + sign(t, jwt.SigningMethodHS256, testSecret, expired),
+		"not valid yet":    "Bearer " + sign(t, jwt.SigningMethodHS256, testSecret, notYetValid),
+		"no expiry":        "Bearer " + sign(t, jwt.SigningMethodHS256, testSecret, noExpiry),
+		"wrong issuer":     "Bearer " + sign(t, jwt.SigningMethodHS256, testSecret, wrongIssuer),
+		"wrong audience":   "Bearer " + sign(t, jwt.SigningMethodHS256, testSecret, wrongAudience),
+		"garbage token":    "Bearer not.a.jwt",
+		"unconfigured alg": "Bearer " + sign(t, jwt.SigningMethodHS384, testSecret, validClaims()),
+	}
+	for name, header := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, http.StatusUnauthorized, doRequest(t, app, header))
+		})
+	}
+}
+
+func writePublicKey(t *testing.T, dir, name string, pub interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	assert.NoError(t, err)
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o600))
+	return path
+}
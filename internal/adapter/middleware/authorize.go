@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	RoleDriver     = "driver"
+	RoleDispatcher = "dispatcher"
+	RoleAdmin      = "admin"
+)
+
+// RequireRole only lets requests through whose token grants at least one of
+// the roles. It must run after RequireAuthenticated.
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		for _, role := range roles {
+			if HasRole(c, role) {
+				return c.Next()
+			}
+		}
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "requires one of roles: " + strings.Join(roles, ", "),
+		})
+	}
+}
+
+// HasRole reports whether the verified token grants the role, either through
+// the "roles" claim, a single "role" claim or the space separated "scope".
+func HasRole(c *fiber.Ctx, role string) bool {
+	for _, r := range Roles(Claims(c)) {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func Roles(claims jwt.MapClaims) []string {
+	var roles []string
+	switch v := claims["roles"].(type) {
+	case []interface{}:
+		for _, r := range v {
+			if s, ok := r.(string); ok {
+				roles = append(roles, s)
+			}
+		}
+	case string:
+		roles = append(roles, v)
+	}
+	if role, ok := claims["role"].(string); ok {
+		roles = append(roles, role)
+	}
+	if scope, ok := claims["scope"].(string); ok {
+		roles = append(roles, strings.Fields(scope)...)
+	}
+	return roles
+}
+
+// DriverID returns the driver the token was issued to, taken from the numeric
+// "driver_id" claim.
+func DriverID(c *fiber.Ctx) (int, bool) {
+	id, ok := Claims(c)["driver_id"].(float64)
+	if !ok || id != float64(int(id)) {
+		return 0, false
+	}
+	return int(id), true
+}
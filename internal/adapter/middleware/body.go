@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"io"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// LimitBody buffers the request body and rejects it when it is larger than
+// the app's BodyLimit. With StreamRequestBody enabled fasthttp hands bodies
+// of any size to the handlers, so routes that read the whole body must run
+// behind it.
+func LimitBody() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit := c.App().Config().BodyLimit
+		if c.Request().Header.ContentLength() > limit {
+			return tooLarge(c)
+		}
+
+		stream := c.Context().RequestBodyStream()
+		if stream == nil {
+			return c.Next()
+		}
+		body, err := io.ReadAll(io.LimitReader(stream, int64(limit)+1))
+		if err != nil {
+			return fiber.ErrBadRequest
+		}
+		if len(body) > limit {
+			return tooLarge(c)
+		}
+		c.Request().SetBody(body)
+		return c.Next()
+	}
+}
+
+// tooLarge rejects the request and closes the connection, as the rest of the
+// body is still unread and cannot be followed by another request.
+func tooLarge(c *fiber.Ctx) error {
+	c.Context().SetConnectionClose()
+	return fiber.ErrRequestEntityTooLarge
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupBodyApp() *fiber.App {
+	app := fiber.New(fiber.Config{StreamRequestBody: true, BodyLimit: 16})
+	app.Post("/", LimitBody(), func(c *fiber.Ctx) error {
+		return c.Send(c.Body())
+	})
+	return app
+}
+
+func TestLimitBody(t *testing.T) {
+	cases := map[string]struct {
+		body    string
+		chunked bool
+		status  int
+	}{
+		"within limit":           {body: `{"driver_id":1}`, status: http.StatusOK},
+		"content length":         {body: strings.Repeat("x", 17), status: http.StatusRequestEntityTooLarge},
+		"chunked within limit":   {body: `{"driver_id":1}`, chunked: true, status: http.StatusOK},
+		"chunked over the limit": {body: strings.Repeat("x", 1<<16), chunked: true, status: http.StatusRequestEntityTooLarge},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tc.body))
+			if tc.chunked {
+				req.ContentLength = -1
+				req.TransferEncoding = []string{"chunked"}
+			}
+			resp, err := setupBodyApp().Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.status, resp.StatusCode)
+			if tc.status == http.StatusOK {
+				body, _ := io.ReadAll(resp.Body)
+				assert.Equal(t, tc.body, string(body))
+			}
+		})
+	}
+}
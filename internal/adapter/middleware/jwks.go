@@ -0,0 +1,233 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minOnDemandRefresh limits how often an unknown kid can trigger a refresh, so
+// tokens with made-up key ids cannot hammer the identity provider.
+const minOnDemandRefresh = 30 * time.Second
+
+// maxJWKSSize bounds the JWKS document read from the source.
+const maxJWKSSize = 1 << 20
+
+// JWKS is a cache of the verification keys of a JSON Web Key Set, indexed by
+// key id. The set is loaded from a local file or an HTTP(S) URL.
+type JWKS struct {
+	source   string
+	client   *http.Client
+	interval time.Duration
+
+	mu          sync.RWMutex
+	keys        map[string]interface{}
+	lastAttempt time.Time // start of the latest refresh, failed or not
+
+	refreshMu sync.Mutex
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// NewJWKS loads the key set from source and returns a cache that is refreshed
+// every interval once Run is started.
+func NewJWKS(ctx context.Context, source string, interval time.Duration) (*JWKS, error) {
+	j := &JWKS{
+		source:   source,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		interval: interval,
+		keys:     map[string]interface{}{},
+	}
+	if err := j.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// Run refreshes the key set periodically until ctx is done. Failed refreshes
+// are logged and the previously loaded keys are kept.
+func (j *JWKS) Run(ctx context.Context) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := j.Refresh(ctx); err != nil {
+				log.Printf("jwks refresh error: %v", err)
+			}
+		}
+	}
+}
+
+// Refresh reloads the key set from its source.
+func (j *JWKS) Refresh(ctx context.Context) error {
+	j.refreshMu.Lock()
+	defer j.refreshMu.Unlock()
+
+	return j.load(ctx)
+}
+
+func (j *JWKS) load(ctx context.Context) error {
+	j.mu.Lock()
+	j.lastAttempt = time.Now()
+	j.mu.Unlock()
+
+	data, err := j.fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("decode jwks: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(doc.Keys))
+	for _, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Printf("jwks: skipping key %q: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.mu.Unlock()
+	return nil
+}
+
+// Key returns the key with the given id. An unknown id triggers a refresh, at
+// most once every minOnDemandRefresh, to pick up newly rotated keys. Failed
+// refreshes count too, so an unreachable source is not retried per request.
+func (j *JWKS) Key(ctx context.Context, kid string) (interface{}, bool) {
+	if key, ok := j.lookup(kid); ok {
+		return key, true
+	}
+
+	j.refreshMu.Lock()
+	j.mu.RLock()
+	stale := time.Since(j.lastAttempt) > minOnDemandRefresh
+	j.mu.RUnlock()
+	if stale {
+		if err := j.load(ctx); err != nil {
+			log.Printf("jwks refresh error: %v", err)
+		}
+	}
+	j.refreshMu.Unlock()
+
+	return j.lookup(kid)
+}
+
+func (j *JWKS) lookup(kid string) (interface{}, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	key, ok := j.keys[kid]
+	return key, ok
+}
+
+func (j *JWKS) fetch(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(j.source, "http://") && !strings.HasPrefix(j.source, "https://") {
+		return os.ReadFile(j.source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, j.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch jwks: unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() {
+			return nil, errors.New("rsa exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if x.BitLen() > 256 || y.BitLen() > 256 {
+			return nil, errors.New("invalid P-256 coordinates")
+		}
+		// ecdh rejects coordinates that are not a point on the curve
+		point := append([]byte{4}, append(x.FillBytes(make([]byte, 32)), y.FillBytes(make([]byte, 32))...)...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(v string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,191 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// jwksServer serves a key set that tests can swap to simulate rotation.
+type jwksServer struct {
+	mu       sync.Mutex
+	doc      []byte
+	requests atomic.Int32
+	fail     atomic.Bool
+}
+
+func (s *jwksServer) set(t *testing.T, keys ...map[string]string) {
+	t.Helper()
+	doc, err := json.Marshal(map[string]interface{}{"keys": keys})
+	assert.NoError(t, err)
+	s.mu.Lock()
+	s.doc = doc
+	s.mu.Unlock()
+}
+
+func (s *jwksServer) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	s.requests.Add(1)
+	if s.fail.Load() {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(s.doc)
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func signWithKid(t *testing.T, method jwt.SigningMethod, kid string, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, validClaims())
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	assert.NoError(t, err)
+	return signed
+}
+
+func TestJWKS_SelectsKeyByKid(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	srv := &jwksServer{}
+	srv.set(t, rsaJWK("rsa-1", &rsaKey.PublicKey), ecJWK("ec-1", &ecKey.PublicKey))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	jwks, err := NewJWKS(context.Background(), ts.URL, time.Hour)
+	assert.NoError(t, err)
+	app := setupApp(AuthConfig{JWKS: jwks})
+
+	assert.Equal(t, http.StatusOK, doRequest(t, app, "Bearer "+signWithKid(t, jwt.SigningMethodRS256, "rsa-1", rsaKey)))
+	assert.Equal(t, http.StatusOK, doRequest(t, app, "Bearer "+signWithKid(t, jwt.SigningMethodES256, "ec-1", ecKey)))
+	// a valid signature under the wrong kid must not verify
+	assert.Equal(t, http.StatusUnauthorized, doRequest(t, app, "Bearer "+signWithKid(t, jwt.SigningMethodRS256, "ec-1", rsaKey)))
+}
+
+func TestJWKS_PicksUpRotatedKeys(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	srv := &jwksServer{}
+	srv.set(t, rsaJWK("old", &oldKey.PublicKey))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	jwks, err := NewJWKS(ctx, ts.URL, 20*time.Millisecond)
+	assert.NoError(t, err)
+	go jwks.Run(ctx)
+	app := setupApp(AuthConfig{JWKS: jwks})
+
+	token := "Bearer " + signWithKid(t, jwt.SigningMethodRS256, "new", newKey)
+	assert.Equal(t, http.StatusUnauthorized, doRequest(t, app, token))
+
+	srv.set(t, rsaJWK("old", &oldKey.PublicKey), rsaJWK("new", &newKey.PublicKey))
+	assert.Eventually(t, func() bool {
+		return doRequest(t, app, token) == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestJWKS_UnknownKidRefreshIsRateLimited(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	srv := &jwksServer{}
+	srv.set(t, rsaJWK("known", &key.PublicKey))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	jwks, err := NewJWKS(context.Background(), ts.URL, time.Hour)
+	assert.NoError(t, err)
+	app := setupApp(AuthConfig{JWKS: jwks})
+
+	for i := 0; i < 5; i++ {
+		token := "Bearer " + signWithKid(t, jwt.SigningMethodRS256, "made-up", key)
+		assert.Equal(t, http.StatusUnauthorized, doRequest(t, app, token))
+	}
+	assert.Equal(t, int32(1), srv.requests.Load())
+
+	token := signWithKid(t, jwt.SigningMethodRS256, "made-up", key)
+	_, err = AuthConfig{JWKS: jwks}.verify(context.Background(), token)
+	assert.EqualError(t, err, `signing key "made-up" not found`)
+}
+
+func TestJWKS_FailedRefreshIsRateLimited(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	srv := &jwksServer{}
+	srv.set(t, rsaJWK("known", &key.PublicKey))
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	jwks, err := NewJWKS(context.Background(), ts.URL, time.Hour)
+	assert.NoError(t, err)
+	jwks.lastAttempt = time.Now().Add(-time.Hour)
+	srv.fail.Store(true)
+	app := setupApp(AuthConfig{JWKS: jwks})
+
+	for i := 0; i < 5; i++ {
+		token := "Bearer " + signWithKid(t, jwt.SigningMethodRS256, "made-up", key)
+		assert.Equal(t, http.StatusUnauthorized, doRequest(t, app, token))
+	}
+	assert.Equal(t, int32(2), srv.requests.Load())
+}
+
+func TestJWKS_LoadsFromFile(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	doc, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{ecJWK("file-1", &key.PublicKey)}})
+	assert.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(path, doc, 0o600))
+
+	jwks, err := NewJWKS(context.Background(), path, time.Hour)
+	assert.NoError(t, err)
+	app := setupApp(AuthConfig{JWKS: jwks})
+
+	assert.Equal(t, http.StatusOK, doRequest(t, app, "Bearer "+signWithKid(t, jwt.SigningMethodES256, "file-1", key)))
+}
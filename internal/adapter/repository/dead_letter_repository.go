@@ -0,0 +1,35 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"github.com/envercigal/golang/internal/core/domain"
+	"github.com/envercigal/golang/internal/core/port"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const deadLetterCollection = "import_dead_letters"
+
+type deadLetterRepo struct {
+	letters *mongo.Collection
+}
+
+func NewDeadLetterRepo(db *mongo.Database) (port.DeadLetterStore, error) {
+	_, err := db.Collection(deadLetterCollection).Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "job_id", Value: 1}, {Key: "failed_at", Value: 1}},
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create indexes of %s: %w", deadLetterCollection, err)
+	}
+
+	return &deadLetterRepo{letters: db.Collection(deadLetterCollection)}, nil
+}
+
+func (r *deadLetterRepo) Add(ctx context.Context, letter *domain.DeadLetter) error {
+	_, err := r.letters.InsertOne(ctx, letter)
+	return err
+}
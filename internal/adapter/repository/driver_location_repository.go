@@ -3,37 +3,188 @@ package mongo
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/envercigal/golang/internal/core/domain"
 	"github.com/envercigal/golang/internal/core/port"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"io"
+	"time"
 )
 
+const (
+	currentCollection = "driver_current_locations"
+	historyCollection = "driver_locations"
+
+	duplicateKeyCode          = 11000
+	namespaceNotFoundCode     = 26
+	indexNotFoundCode         = 27
+	indexOptionsConflictCode  = 85
+	indexKeySpecsConflictCode = 86
+
+	currentTTLIndex = "updated_at_1"
+)
+
+// driverLocationRepo keeps the latest position of every driver in the current
+// collection, which all geo queries run against, and every received ping in
+// the history collection.
 type driverLocationRepo struct {
-	coll *mongo.Collection
+	current *mongo.Collection
+	history *mongo.Collection
 }
 
-func NewDriverLocationRepo(db *mongo.Database) port.DriverLocationRepository {
-	_, err := db.Collection("driver_locations").Indexes().CreateOne(
-		context.Background(),
-		mongo.IndexModel{
-			Keys: bson.D{{Key: "location", Value: "2dsphere"}},
-		},
-	)
+type Option func(*repoConfig)
+
+type repoConfig struct {
+	currentTTL time.Duration
+}
+
+// WithCurrentTTL makes Mongo expire current positions that have not been
+// updated for ttl. The history is never expired.
+func WithCurrentTTL(ttl time.Duration) Option {
+	return func(c *repoConfig) {
+		c.currentTTL = ttl
+	}
+}
+
+// NewDriverLocationRepo creates the indexes of both collections. It fails
+// when an index cannot be created, since the queries and imports rely on
+// them.
+func NewDriverLocationRepo(db *mongo.Database, opts ...Option) (port.DriverLocationRepository, error) {
+	var cfg repoConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.currentTTL != 0 && cfg.currentTTL < time.Second {
+		return nil, fmt.Errorf("current location TTL must be at least 1s, got %s", cfg.currentTTL)
+	}
+
+	ctx := context.Background()
+	current := db.Collection(currentCollection)
+	_, err := current.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "location", Value: "2dsphere"}}},
+		{Keys: bson.D{{Key: "driver_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+	})
 	if err != nil {
-		return nil
+		return nil, fmt.Errorf("create indexes of %s: %w", currentCollection, err)
+	}
+	if err := createTTLIndex(ctx, db, cfg.currentTTL); err != nil {
+		return nil, fmt.Errorf("create TTL index of %s: %w", currentCollection, err)
+	}
 
+	if err := createHistoryIndex(ctx, db.Collection(historyCollection)); err != nil {
+		return nil, fmt.Errorf("create index of %s: %w", historyCollection, err)
 	}
 
 	return &driverLocationRepo{
-		coll: db.Collection("driver_locations"),
+		current: current,
+		history: db.Collection(historyCollection),
+	}, nil
+}
+
+// createTTLIndex expires current positions after ttl, or stops expiring them
+// when ttl is 0. A TTL changed since the last start is applied to the
+// existing index.
+func createTTLIndex(ctx context.Context, db *mongo.Database, ttl time.Duration) error {
+	indexes := db.Collection(currentCollection).Indexes()
+	if ttl == 0 {
+		_, err := indexes.DropOne(ctx, currentTTLIndex)
+		if isCommandError(err, namespaceNotFoundCode, indexNotFoundCode) {
+			return nil
+		}
+		return err
 	}
+
+	seconds := int32(ttl / time.Second)
+	_, err := indexes.CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "updated_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(seconds),
+	})
+	if !isCommandError(err, indexOptionsConflictCode) {
+		return err
+	}
+	return db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: currentCollection},
+		{Key: "index", Value: bson.D{
+			{Key: "name", Value: currentTTLIndex},
+			{Key: "expireAfterSeconds", Value: seconds},
+		}},
+	}).Err()
+}
+
+func isCommandError(err error, codes ...int32) bool {
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	for _, code := range codes {
+		if cmdErr.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// createHistoryIndex makes (driver_id, updated_at) the natural key of the
+// history, so importing the same rows twice does not duplicate them. The
+// non-unique index of earlier versions is replaced.
+//
+// Earlier versions could store several pings of a driver with the same time,
+// and the index cannot be built while they exist. They may hold different
+// positions, so rather than deleting any of them the error tells the operator
+// to resolve them.
+func createHistoryIndex(ctx context.Context, history *mongo.Collection) error {
+	model := mongo.IndexModel{
+		Keys:    bson.D{{Key: "driver_id", Value: 1}, {Key: "updated_at", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+	_, err := history.Indexes().CreateOne(ctx, model)
+	if isCommandError(err, indexOptionsConflictCode, indexKeySpecsConflictCode) {
+		// keep the old index until the new one is known to build
+		duplicates, err := hasDuplicatePings(ctx, history)
+		if err != nil {
+			return err
+		}
+		if duplicates {
+			return errDuplicatePings
+		}
+		if _, err := history.Indexes().DropOne(ctx, "driver_id_1_updated_at_1"); err != nil {
+			return err
+		}
+		_, err = history.Indexes().CreateOne(ctx, model)
+		return err
+	}
+	if isCommandError(err, duplicateKeyCode) {
+		return fmt.Errorf("%w: %w", errDuplicatePings, err)
+	}
+	return err
+}
+
+var errDuplicatePings = errors.New(historyCollection + " holds several pings of the same driver with the same updated_at; " +
+	"remove or re-time the duplicates before starting this version")
+
+// hasDuplicatePings reports whether any driver has two pings with the same
+// updated_at.
+func hasDuplicatePings(ctx context.Context, history *mongo.Collection) (bool, error) {
+	cur, err := history.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.M{
+			"_id":   bson.M{"driver_id": "$driver_id", "updated_at": "$updated_at"},
+			"count": bson.M{"$sum": 1},
+		}}},
+		{{Key: "$match", Value: bson.M{"count": bson.M{"$gt": 1}}}},
+		{{Key: "$limit", Value: 1}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return false, err
+	}
+	defer cur.Close(ctx)
+	return cur.Next(ctx), cur.Err()
 }
 
 func (r *driverLocationRepo) Create(ctx context.Context, dl *domain.DriverLocation) (*domain.DriverLocation, error) {
-	res, err := r.coll.InsertOne(ctx, dl)
+	res, err := r.history.InsertOne(ctx, dl)
 	if err != nil {
 		return nil, err
 	}
@@ -42,41 +193,335 @@ func (r *driverLocationRepo) Create(ctx context.Context, dl *domain.DriverLocati
 		return nil, errors.New("failed to convert InsertedID to ObjectID")
 	}
 	dl.ID = oid
+
+	if err := r.upsertCurrent(ctx, []*domain.DriverLocation{dl}); err != nil {
+		return nil, err
+	}
 	return dl, nil
 }
 
-func (r *driverLocationRepo) BulkCreate(ctx context.Context, batch []*domain.DriverLocation) error {
+func (r *driverLocationRepo) BulkCreate(ctx context.Context, batch []*domain.DriverLocation) (int, error) {
 	docs := make([]interface{}, len(batch))
 	for i, dl := range batch {
 		docs[i] = dl
 	}
 
 	// Synchronized, acknowledged insert
-	_, err := r.coll.InsertMany(
+	_, err := r.history.InsertMany(
 		ctx,
 		docs,
 		options.InsertMany().
 			SetOrdered(false).                 // hata olsa bile devam et
 			SetBypassDocumentValidation(true), // validasyon maliyetini atla
 	)
+	duplicates, ok := duplicateKeys(err)
+	if err != nil && !ok {
+		return 0, transient(err)
+	}
+
+	if err := r.upsertCurrent(ctx, batch); err != nil {
+		return 0, transient(err)
+	}
+	return duplicates, nil
+}
+
+// upsertCurrent moves the current position of each driver in the batch to
+// its latest location. Positions older than the stored one are ignored.
+func (r *driverLocationRepo) upsertCurrent(ctx context.Context, batch []*domain.DriverLocation) error {
+	latest := make(map[int]*domain.DriverLocation, len(batch))
+	for _, dl := range batch {
+		if prev, ok := latest[dl.DriverID]; !ok || dl.Updated.After(prev.Updated) {
+			latest[dl.DriverID] = dl
+		}
+	}
+
+	if len(latest) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(latest))
+	for _, dl := range latest {
+		models = append(models, currentPositionModel(dl).SetUpsert(true))
+	}
+
+	_, err := r.current.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+
+	// A duplicate key means the driver already has a position that is newer
+	// than this one, or a concurrent upsert created the document first. The
+	// latter is retried as a plain update so the newer position still wins.
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) || bwe.WriteConcernError != nil {
+		return err
+	}
+	var retries []mongo.WriteModel
+	for _, we := range bwe.WriteErrors {
+		if we.Code != duplicateKeyCode {
+			return err
+		}
+		retries = append(retries, models[we.Index].(*mongo.UpdateOneModel).SetUpsert(false))
+	}
+	if len(retries) == 0 {
+		return nil
+	}
+	_, err = r.current.BulkWrite(ctx, retries, options.BulkWrite().SetOrdered(false))
 	return err
 }
 
-func (r *driverLocationRepo) FindNearest(ctx context.Context, lon, lat float64) (*domain.DriverLocation, error) {
+// retryableCodes are the server errors the driver's retryable writes retry
+// as well: elections, shutdowns and network failures between the nodes.
+var retryableCodes = []int{6, 7, 89, 91, 189, 262, 9001, 10107, 11600, 11602, 13435, 13436}
+
+// transient marks errors that may succeed when the write is retried with
+// domain.ErrTransient.
+func transient(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	retry := mongo.IsNetworkError(err) || mongo.IsTimeout(err)
+
+	var labeled mongo.LabeledError
+	if errors.As(err, &labeled) && labeled.HasErrorLabel("RetryableWriteError") {
+		retry = true
+	}
+	var serverErr mongo.ServerError
+	if errors.As(err, &serverErr) {
+		for _, code := range retryableCodes {
+			retry = retry || serverErr.HasErrorCode(code)
+		}
+	}
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) && bwe.WriteConcernError != nil {
+		retry = true
+	}
+
+	if !retry {
+		return err
+	}
+	return fmt.Errorf("%w: %w", domain.ErrTransient, err)
+}
+
+// duplicateKeys returns the number of writes of a bulk insert that failed
+// because the document was already stored. It reports false when any write
+// failed for another reason.
+func duplicateKeys(err error) (int, bool) {
+	if err == nil {
+		return 0, true
+	}
+	var bwe mongo.BulkWriteException
+	if !errors.As(err, &bwe) || bwe.WriteConcernError != nil || len(bwe.WriteErrors) == 0 {
+		return 0, false
+	}
+	for _, we := range bwe.WriteErrors {
+		if we.Code != duplicateKeyCode {
+			return 0, false
+		}
+	}
+	return len(bwe.WriteErrors), true
+}
+
+func currentPositionModel(dl *domain.DriverLocation) *mongo.UpdateOneModel {
+	doc := *dl
+	doc.ID = primitive.NilObjectID // the history id is not the current document id
+
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{
+			"driver_id":  dl.DriverID,
+			"updated_at": bson.M{"$lte": dl.Updated},
+		}).
+		SetUpdate(bson.M{"$set": doc})
+}
+
+func (r *driverLocationRepo) FindNearest(ctx context.Context, lon, lat float64, f domain.LocationFilter) (*domain.DriverLocation, error) {
+	filter := locationFilter(f)
+	filter["location"] = bson.M{
+		"$near": bson.M{
+			"$geometry": bson.M{
+				"type":        "Point",
+				"coordinates": []float64{lon, lat},
+			},
+		},
+	}
+
+	var dl domain.DriverLocation
+	if err := r.current.FindOne(ctx, filter).Decode(&dl); err != nil {
+		return nil, err
+	}
+	return &dl, nil
+}
+
+func (r *driverLocationRepo) FindNear(ctx context.Context, q domain.NearQuery) ([]*domain.DriverDistance, error) {
+	geoNear := bson.M{
+		"near": bson.M{
+			"type":        "Point",
+			"coordinates": []float64{q.Longitude, q.Latitude},
+		},
+		"distanceField": "distance",
+		"spherical":     true,
+		"query":         locationFilter(q.Filter),
+	}
+	if q.MaxDistance > 0 {
+		geoNear["maxDistance"] = q.MaxDistance
+	}
+
+	pipeline := mongo.Pipeline{{{Key: "$geoNear", Value: geoNear}}}
+	if q.Skip > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$skip", Value: q.Skip}})
+	}
+	if q.Limit > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$limit", Value: q.Limit}})
+	}
+
+	cur, err := r.current.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*domain.DriverDistance, 0)
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (r *driverLocationRepo) FindWithin(ctx context.Context, q domain.WithinQuery) ([]*domain.DriverLocation, error) {
+	filter := locationFilter(q.Filter)
+	if q.Box != nil {
+		filter["location"] = boxFilter(*q.Box)
+	} else {
+		filter["location"] = bson.M{
+			"$geoWithin": bson.M{
+				"$geometry": q.Polygon,
+			},
+		}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if q.Skip > 0 {
+		opts.SetSkip(int64(q.Skip))
+	}
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+
+	cur, err := r.current.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*domain.DriverLocation, 0)
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (r *driverLocationRepo) FindHistory(ctx context.Context, q domain.HistoryQuery) ([]*domain.DriverLocation, error) {
 	filter := bson.M{
-		"location": bson.M{
-			"$near": bson.M{
-				"$geometry": bson.M{
-					"type":        "Point",
-					"coordinates": []float64{lon, lat},
-				},
+		"driver_id": q.DriverID,
+		"updated_at": bson.M{
+			"$gte": q.From,
+			"$lte": q.To,
+		},
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "updated_at", Value: 1}})
+	if q.Limit > 0 {
+		opts.SetLimit(int64(q.Limit))
+	}
+
+	cur, err := r.history.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*domain.DriverLocation, 0)
+	if err := cur.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func locationFilter(f domain.LocationFilter) bson.M {
+	filter := bson.M{}
+	if !f.UpdatedAfter.IsZero() {
+		filter["updated_at"] = bson.M{"$gte": f.UpdatedAfter}
+	}
+	if len(f.Statuses) > 0 {
+		filter["status"] = bson.M{"$in": f.Statuses}
+	}
+	if len(f.VehicleTypes) > 0 {
+		filter["vehicle_type"] = bson.M{"$in": f.VehicleTypes}
+	}
+	return filter
+}
+
+// boxFilter matches locations inside the box using flat lon/lat geometry,
+// so its edges follow the parallels and meridians a map viewport shows
+// rather than the great-circle arcs of a GeoJSON polygon.
+func boxFilter(b domain.BoundingBox) bson.M {
+	return bson.M{
+		"$geoWithin": bson.M{
+			"$box": bson.A{
+				bson.A{b.MinLon, b.MinLat},
+				bson.A{b.MaxLon, b.MaxLat},
 			},
 		},
 	}
+}
+
+// exportBatchSize is the number of documents fetched per cursor round trip.
+const exportBatchSize = 1000
+
+func (r *driverLocationRepo) Export(ctx context.Context, q domain.ExportQuery) (port.LocationIterator, error) {
+	filter := locationFilter(domain.LocationFilter{Statuses: q.Statuses, VehicleTypes: q.VehicleTypes})
+	updated := bson.M{}
+	if !q.From.IsZero() {
+		updated["$gte"] = q.From
+	}
+	if !q.To.IsZero() {
+		updated["$lte"] = q.To
+	}
+	if len(updated) > 0 {
+		filter["updated_at"] = updated
+	}
+	if q.Box != nil {
+		filter["location"] = boxFilter(*q.Box)
+	}
 
+	coll := r.current
+	opts := options.Find().SetBatchSize(exportBatchSize)
+	if q.Source == domain.SourceHistory {
+		// the sort is served by the (driver_id, updated_at) index
+		coll = r.history
+		opts.SetSort(bson.D{{Key: "driver_id", Value: 1}, {Key: "updated_at", Value: 1}})
+	}
+
+	cur, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &locationCursor{cur: cur}, nil
+}
+
+type locationCursor struct {
+	cur *mongo.Cursor
+}
+
+func (c *locationCursor) Next(ctx context.Context) (*domain.DriverLocation, error) {
+	if !c.cur.Next(ctx) {
+		if err := c.cur.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
 	var dl domain.DriverLocation
-	if err := r.coll.FindOne(ctx, filter).Decode(&dl); err != nil {
+	if err := c.cur.Decode(&dl); err != nil {
 		return nil, err
 	}
 	return &dl, nil
 }
+
+func (c *locationCursor) Close(ctx context.Context) error {
+	return c.cur.Close(ctx)
+}
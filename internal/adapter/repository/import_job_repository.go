@@ -0,0 +1,60 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/envercigal/golang/internal/core/domain"
+	"github.com/envercigal/golang/internal/core/port"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const importJobCollection = "import_jobs"
+
+// importJobRepo stores one document per import job. A resumed import keeps
+// its job id, so every key belongs to a single job.
+type importJobRepo struct {
+	jobs *mongo.Collection
+}
+
+func NewImportJobRepo(db *mongo.Database) (port.ImportJobStore, error) {
+	_, err := db.Collection(importJobCollection).Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "key", Value: 1}},
+			Options: options.Index().SetUnique(true).SetSparse(true),
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create indexes of %s: %w", importJobCollection, err)
+	}
+
+	return &importJobRepo{jobs: db.Collection(importJobCollection)}, nil
+}
+
+func (r *importJobRepo) Save(ctx context.Context, job *domain.ImportJob) error {
+	_, err := r.jobs.ReplaceOne(ctx, bson.M{"_id": job.ID}, job, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (r *importJobRepo) Find(ctx context.Context, id string) (*domain.ImportJob, error) {
+	return r.findOne(ctx, bson.M{"_id": id}, "import job "+id)
+}
+
+func (r *importJobRepo) FindByKey(ctx context.Context, key string) (*domain.ImportJob, error) {
+	return r.findOne(ctx, bson.M{"key": key}, "import job with key "+key)
+}
+
+func (r *importJobRepo) findOne(ctx context.Context, filter bson.M, what string) (*domain.ImportJob, error) {
+	var job domain.ImportJob
+	err := r.jobs.FindOne(ctx, filter).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("%s: %w", what, domain.ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
@@ -10,9 +10,105 @@ type GeoJSONPoint struct {
 	Coordinates []float64 `bson:"coordinates" json:"coordinates"` // [lat, lon]
 }
 
+type GeoJSONPolygon struct {
+	Type        string        `bson:"type" json:"type"`
+	Coordinates [][][]float64 `bson:"coordinates" json:"coordinates"` // rings of [lon, lat]
+}
+
+// BoundingBox is an axis-aligned lon/lat rectangle such as a map viewport.
+type BoundingBox struct {
+	MinLon float64 `json:"min_lon"`
+	MinLat float64 `json:"min_lat"`
+	MaxLon float64 `json:"max_lon"`
+	MaxLat float64 `json:"max_lat"`
+}
+
+type DriverStatus string
+
+const (
+	StatusAvailable DriverStatus = "available"
+	StatusOnTrip    DriverStatus = "on_trip"
+	StatusOffline   DriverStatus = "offline"
+	StatusBreak     DriverStatus = "break"
+)
+
+func (s DriverStatus) Valid() bool {
+	switch s {
+	case StatusAvailable, StatusOnTrip, StatusOffline, StatusBreak:
+		return true
+	default:
+		return false
+	}
+}
+
 type DriverLocation struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	DriverID int                `bson:"driver_id"         json:"driver_id"`
-	Location GeoJSONPoint       `bson:"location"          json:"location"`
-	Updated  time.Time          `bson:"updated_at"        json:"updated_at"`
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	DriverID    int                `bson:"driver_id"         json:"driver_id"`
+	Location    GeoJSONPoint       `bson:"location"          json:"location"`
+	Status      DriverStatus       `bson:"status"            json:"status"`
+	VehicleType string             `bson:"vehicle_type,omitempty" json:"vehicle_type,omitempty"`
+	Updated     time.Time          `bson:"updated_at"        json:"updated_at"`
+}
+
+// DriverDistance is a driver location annotated with its distance in meters
+// from the query point.
+type DriverDistance struct {
+	DriverLocation `bson:",inline"`
+	Distance       float64 `bson:"distance" json:"distance"`
+}
+
+// LocationFilter narrows geo queries to matching driver locations.
+type LocationFilter struct {
+	// MaxAge is the freshness window requested by the caller. The service
+	// resolves it, or its configured default, into UpdatedAfter.
+	MaxAge       time.Duration
+	UpdatedAfter time.Time
+	Statuses     []DriverStatus
+	VehicleTypes []string
+}
+
+// NearQuery describes a distance-ordered geo query around a point.
+type NearQuery struct {
+	Longitude   float64
+	Latitude    float64
+	MaxDistance float64 // meters, zero means unbounded
+	Skip        int
+	Limit       int
+	Filter      LocationFilter
+}
+
+// WithinQuery selects drivers inside an area given either as a bounding box
+// or as a polygon.
+type WithinQuery struct {
+	Box     *BoundingBox
+	Polygon *GeoJSONPolygon
+	Skip    int
+	Limit   int
+	Filter  LocationFilter
+}
+
+// HistoryQuery selects the recorded pings of one driver in [From, To].
+type HistoryQuery struct {
+	DriverID int
+	From     time.Time
+	To       time.Time
+	Limit    int
+}
+
+type LocationSource string
+
+const (
+	SourceCurrent LocationSource = "current"
+	SourceHistory LocationSource = "history"
+)
+
+// ExportQuery selects the locations of an export. Zero times leave the range
+// open on that side and a nil box does not restrict the area.
+type ExportQuery struct {
+	Source       LocationSource
+	From         time.Time
+	To           time.Time
+	Box          *BoundingBox
+	Statuses     []DriverStatus
+	VehicleTypes []string
 }
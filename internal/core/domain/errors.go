@@ -0,0 +1,13 @@
+package domain
+
+import "errors"
+
+// ErrInvalidArgument is wrapped by validation errors caused by caller input.
+var ErrInvalidArgument = errors.New("invalid argument")
+
+// ErrNotFound is returned when a requested resource does not exist.
+var ErrNotFound = errors.New("not found")
+
+// ErrTransient is wrapped by storage errors that may succeed when retried,
+// such as timeouts, network errors and primary elections.
+var ErrTransient = errors.New("transient error")
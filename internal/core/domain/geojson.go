@@ -0,0 +1,50 @@
+package domain
+
+type GeoJSONLineString struct {
+	Type        string      `json:"type"`
+	Coordinates [][]float64 `json:"coordinates"`
+}
+
+type GeoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   interface{}            `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// NewLineString joins the locations, in order, into a single line.
+func NewLineString(points []*DriverLocation) GeoJSONLineString {
+	coords := make([][]float64, 0, len(points))
+	for _, p := range points {
+		coords = append(coords, p.Location.Coordinates)
+	}
+	return GeoJSONLineString{Type: "LineString", Coordinates: coords}
+}
+
+// NewPointFeature returns the location as a Point feature carrying the
+// driver attributes as properties.
+func NewPointFeature(dl *DriverLocation) GeoJSONFeature {
+	return GeoJSONFeature{
+		Type:     "Feature",
+		Geometry: dl.Location,
+		Properties: map[string]interface{}{
+			"driver_id":    dl.DriverID,
+			"timestamp":    dl.Updated,
+			"status":       dl.Status,
+			"vehicle_type": dl.VehicleType,
+		},
+	}
+}
+
+// NewFeatureCollection returns one Point feature per location.
+func NewFeatureCollection(points []*DriverLocation) GeoJSONFeatureCollection {
+	features := make([]GeoJSONFeature, 0, len(points))
+	for _, p := range points {
+		features = append(features, NewPointFeature(p))
+	}
+	return GeoJSONFeatureCollection{Type: "FeatureCollection", Features: features}
+}
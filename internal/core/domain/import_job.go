@@ -0,0 +1,118 @@
+package domain
+
+import "time"
+
+type ImportJobStatus string
+
+const (
+	ImportRunning   ImportJobStatus = "running"
+	ImportCompleted ImportJobStatus = "completed"
+	ImportFailed    ImportJobStatus = "failed"
+	ImportCancelled ImportJobStatus = "cancelled"
+)
+
+// ImportJob is a snapshot of the progress of a background bulk import.
+//
+// Checkpoint is the row up to which every batch has been committed. A resumed
+// import skips those rows, and its counters only cover the rows after the
+// checkpoint it resumed from.
+type ImportJob struct {
+	ID            string          `json:"id" bson:"_id"`
+	Key           string          `json:"key,omitempty" bson:"key,omitempty"`
+	Status        ImportJobStatus `json:"status" bson:"status"`
+	RowsRead      int64           `json:"rows_read" bson:"rows_read"`
+	Inserted      int64           `json:"inserted" bson:"inserted"`
+	Rejected      int64           `json:"rejected" bson:"rejected"`
+	Duplicates    int64           `json:"duplicates" bson:"duplicates"`
+	FailedBatches int64           `json:"failed_batches" bson:"failed_batches"`
+	Checkpoint    int64           `json:"checkpoint" bson:"checkpoint"`
+	Error         string          `json:"error,omitempty" bson:"error,omitempty"`
+	CreatedAt     time.Time       `json:"created_at" bson:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at" bson:"updated_at"`
+	FinishedAt    *time.Time      `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+	Report        *ImportReport   `json:"report,omitempty" bson:"report,omitempty"` // set once finished
+}
+
+func (j *ImportJob) Finished() bool {
+	return j.Status != ImportRunning
+}
+
+// RowError explains why a row of an import was not stored.
+type RowError struct {
+	Row    int64  `json:"row" bson:"row"`
+	Reason string `json:"reason" bson:"reason"`
+}
+
+// ImportReport lists the outcome of an import. Duplicates counts the rows
+// that were not written because the same ping was already stored. Errors
+// holds at most a bounded number of rejected rows; Truncated is set when more
+// were rejected.
+type ImportReport struct {
+	Accepted   int64      `json:"accepted" bson:"accepted"`
+	Rejected   int64      `json:"rejected" bson:"rejected"`
+	Duplicates int64      `json:"duplicates" bson:"duplicates"`
+	Errors     []RowError `json:"errors" bson:"errors"`
+	Truncated  bool       `json:"truncated" bson:"truncated"`
+}
+
+// StreamAck reports the progress of a location stream. Accepted counts the
+// lines that were persisted so far; Errors lists the lines rejected since the
+// previous acknowledgement. The last acknowledgement of a stream is Done.
+type StreamAck struct {
+	Read       int64      `json:"read"`
+	Accepted   int64      `json:"accepted"`
+	Rejected   int64      `json:"rejected"`
+	Duplicates int64      `json:"duplicates"`
+	Errors     []RowError `json:"errors,omitempty"`
+	Done       bool       `json:"done"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// ImportSummary describes an upload that was validated without being
+// written. The bounding box and time range only cover the valid rows.
+type ImportSummary struct {
+	Rows                int64        `json:"rows"`
+	Valid               int64        `json:"valid"`
+	Rejected            int64        `json:"rejected"`
+	BoundingBox         *BoundingBox `json:"bounding_box,omitempty"`
+	From                *time.Time   `json:"from,omitempty"`
+	To                  *time.Time   `json:"to,omitempty"`
+	Drivers             int64        `json:"drivers"`
+	DuplicateDriverIDs  []int        `json:"duplicate_driver_ids"` // drivers with more than one row
+	DuplicatesTruncated bool         `json:"duplicates_truncated"`
+	Errors              []RowError   `json:"errors"`
+	Truncated           bool         `json:"truncated"`
+}
+
+type ImportFormat string
+
+const (
+	FormatCSV     ImportFormat = "csv"
+	FormatGeoJSON ImportFormat = "geojson"
+	FormatNDJSON  ImportFormat = "ndjson"
+)
+
+// ImportOptions describe how an uploaded file is parsed.
+type ImportOptions struct {
+	// Key identifies the uploaded content, either a client supplied
+	// idempotency key or a checksum. Importing a key again returns the
+	// earlier job, or resumes it when it did not complete.
+	Key    string
+	Format ImportFormat
+	// Delimiter separates CSV fields, a comma when zero.
+	Delimiter rune
+	// Columns overrides the CSV header of a column, keyed by driver_id, lat,
+	// lon, timestamp, status or vehicle_type.
+	Columns map[string]string
+}
+
+// DeadLetter is an import batch that could not be written, kept so it can be
+// inspected and replayed.
+type DeadLetter struct {
+	JobID     string            `json:"job_id,omitempty" bson:"job_id,omitempty"`
+	Rows      []int64           `json:"rows" bson:"rows"`
+	Locations []*DriverLocation `json:"locations" bson:"locations"`
+	Attempts  int               `json:"attempts" bson:"attempts"`
+	Error     string            `json:"error" bson:"error"`
+	FailedAt  time.Time         `json:"failed_at" bson:"failed_at"`
+}
@@ -8,12 +8,50 @@ import (
 
 type DriverLocationRepository interface {
 	Create(ctx context.Context, driverLocations *domain.DriverLocation) (*domain.DriverLocation, error)
-	BulkCreate(ctx context.Context, driverLocations []*domain.DriverLocation) error
-	FindNearest(ctx context.Context, longitude, latitude float64) (*domain.DriverLocation, error)
+	// BulkCreate stores the locations and returns how many of them were
+	// skipped because the same ping of the driver was already stored.
+	BulkCreate(ctx context.Context, driverLocations []*domain.DriverLocation) (int, error)
+	FindNearest(ctx context.Context, longitude, latitude float64, filter domain.LocationFilter) (*domain.DriverLocation, error)
+	FindNear(ctx context.Context, query domain.NearQuery) ([]*domain.DriverDistance, error)
+	FindWithin(ctx context.Context, query domain.WithinQuery) ([]*domain.DriverLocation, error)
+	FindHistory(ctx context.Context, query domain.HistoryQuery) ([]*domain.DriverLocation, error)
+	Export(ctx context.Context, query domain.ExportQuery) (LocationIterator, error)
+}
+
+// LocationIterator streams the results of a query without loading them all.
+// Next returns io.EOF once every location was returned.
+type LocationIterator interface {
+	Next(ctx context.Context) (*domain.DriverLocation, error)
+	Close(ctx context.Context) error
 }
 
 type DriverLocationService interface {
 	Create(ctx context.Context, driverLocations *domain.DriverLocation) (*domain.DriverLocation, error)
-	BulkCreate(ctx context.Context, reader io.Reader) error
-	FindNearest(ctx context.Context, longitude, latitude float64) (*domain.DriverLocation, error)
+	BulkCreate(ctx context.Context, reader io.Reader, opts domain.ImportOptions) (*domain.ImportReport, error)
+	StartImport(ctx context.Context, reader io.ReadCloser, opts domain.ImportOptions) (*domain.ImportJob, error)
+	StreamCreate(ctx context.Context, reader io.Reader, ack func(*domain.StreamAck)) error
+	ValidateImport(ctx context.Context, reader io.Reader, opts domain.ImportOptions) (*domain.ImportSummary, error)
+	ImportJob(ctx context.Context, id string) (*domain.ImportJob, error)
+	CancelImport(ctx context.Context, id string) (*domain.ImportJob, error)
+	ImportReport(ctx context.Context, id string) (*domain.ImportReport, error)
+	FindNearest(ctx context.Context, longitude, latitude float64, filter domain.LocationFilter) (*domain.DriverLocation, error)
+	FindKNearest(ctx context.Context, query domain.NearQuery) ([]*domain.DriverDistance, error)
+	FindWithinRadius(ctx context.Context, query domain.NearQuery) ([]*domain.DriverDistance, error)
+	FindWithin(ctx context.Context, query domain.WithinQuery) ([]*domain.DriverLocation, error)
+	Trajectory(ctx context.Context, query domain.HistoryQuery) ([]*domain.DriverLocation, error)
+	Export(ctx context.Context, query domain.ExportQuery) (LocationIterator, error)
+}
+
+// ImportJobStore persists import jobs and their checkpoints, so imports can
+// be resumed after the process running them died.
+type ImportJobStore interface {
+	// Save creates or replaces the job.
+	Save(ctx context.Context, job *domain.ImportJob) error
+	Find(ctx context.Context, id string) (*domain.ImportJob, error)
+	FindByKey(ctx context.Context, key string) (*domain.ImportJob, error)
+}
+
+// DeadLetterStore keeps the import batches that failed every write attempt.
+type DeadLetterStore interface {
+	Add(ctx context.Context, letter *domain.DeadLetter) error
 }
@@ -2,7 +2,6 @@ package service
 
 import (
 	"context"
-	"encoding/csv"
 	"errors"
 	"fmt"
 	"github.com/envercigal/golang/internal/core/domain"
@@ -10,25 +9,78 @@ import (
 	circuitbreaker "github.com/envercigal/golang/pkg"
 	"io"
 	"log"
-	"strconv"
 	"sync"
 	"time"
 )
 
+const (
+	maxNearestLimit = 100
+	maxPageSize     = 500
+
+	maxTrajectoryPoints = 10000
+
+	defaultMaxAge = 5 * time.Minute
+)
+
 type driverLocationService struct {
-	repo       port.DriverLocationRepository
-	breaker    *circuitbreaker.Breaker
-	batchSize  int
-	maxWorkers int
+	repo          port.DriverLocationRepository
+	breaker       *circuitbreaker.Breaker
+	batchSize     int
+	maxWorkers    int
+	writeLatency  time.Duration
+	writeAttempts int
+	retryDelay    time.Duration
+	streamFlush   time.Duration
+	maxAge        time.Duration
+	imports       *importJobs
+	jobStore      port.ImportJobStore
+	deadLetters   port.DeadLetterStore
+}
+
+type Option func(*driverLocationService)
+
+// WithMaxAge sets the default freshness window of geo queries. Drivers whose
+// last ping is older are never returned. Zero disables the filter.
+func WithMaxAge(d time.Duration) Option {
+	return func(s *driverLocationService) {
+		s.maxAge = d
+	}
+}
+
+// WithImportJobStore persists background imports and their checkpoints, so
+// an import can be resumed after the process running it stopped. Without a
+// store, jobs only live in memory.
+func WithImportJobStore(store port.ImportJobStore) Option {
+	return func(s *driverLocationService) {
+		s.jobStore = store
+	}
 }
 
-func NewDriverLocationService(r port.DriverLocationRepository, breaker *circuitbreaker.Breaker) port.DriverLocationService {
-	return &driverLocationService{
-		repo:       r,
-		breaker:    breaker,
-		batchSize:  1000,
-		maxWorkers: 100,
+// WithDeadLetterStore keeps the import batches that failed every write
+// attempt. Without a store, they are only reported as rejected rows.
+func WithDeadLetterStore(store port.DeadLetterStore) Option {
+	return func(s *driverLocationService) {
+		s.deadLetters = store
+	}
+}
+
+func NewDriverLocationService(r port.DriverLocationRepository, breaker *circuitbreaker.Breaker, opts ...Option) port.DriverLocationService {
+	s := &driverLocationService{
+		repo:          r,
+		breaker:       breaker,
+		batchSize:     defaultImportBatchSize,
+		maxWorkers:    defaultImportWorkers,
+		writeLatency:  defaultWriteLatency,
+		writeAttempts: defaultWriteAttempts,
+		retryDelay:    defaultRetryDelay,
+		streamFlush:   defaultStreamFlush,
+		maxAge:        defaultMaxAge,
+		imports:       newImportJobs(),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
 func (s *driverLocationService) Create(ctx context.Context, dl *domain.DriverLocation) (*domain.DriverLocation, error) {
@@ -45,25 +97,44 @@ func (s *driverLocationService) Create(ctx context.Context, dl *domain.DriverLoc
 		return nil, err
 	}
 
+	if dl.Status == "" {
+		dl.Status = domain.StatusAvailable
+	}
+	if !dl.Status.Valid() {
+		return nil, fmt.Errorf("invalid status: %q", dl.Status)
+	}
+
 	dl.Updated = time.Now().UTC()
 	return s.repo.Create(ctx, dl)
 }
 
-func (s *driverLocationService) BulkCreate(ctx context.Context, reader io.Reader) error {
-	csvFile := csv.NewReader(reader)
-	if _, err := csvFile.Read(); err != nil && err != io.EOF {
-		return err
+func (s *driverLocationService) BulkCreate(ctx context.Context, reader io.Reader, opts domain.ImportOptions) (*domain.ImportReport, error) {
+	locations, err := newLocationReader(reader, opts, time.Now().UTC())
+	if err != nil {
+		return nil, err
 	}
 
-	jobs := make(chan []*domain.DriverLocation, s.maxWorkers)
+	progress := &importProgress{}
+	if err := s.runImport(ctx, locations, progress, 0); err != nil {
+		return nil, err
+	}
+	return progress.report(), nil
+}
+
+// runImport writes the locations in batches. A batch is handed to the workers
+// once it is full, or flushAfter after its first row when flushAfter is set.
+func (s *driverLocationService) runImport(ctx context.Context, locations locationReader, progress *importProgress, flushAfter time.Duration) error {
+	// the queue bounds the parsed rows held in memory while writes are slow
+	jobs := make(chan *importBatch, s.maxWorkers)
+	tuner := newWriteTuner(s.maxWorkers, s.batchSize, s.writeLatency)
 	var wg sync.WaitGroup
 
 	for i := 0; i < s.maxWorkers; i++ {
 		wg.Add(1)
-		go s.startWorker(ctx, &wg, jobs)
+		go s.startWorker(ctx, &wg, jobs, tuner, progress)
 	}
 
-	if err := s.produceBatches(csvFile, jobs); err != nil {
+	if err := s.produceBatches(ctx, locations, jobs, tuner, progress, flushAfter); err != nil {
 		close(jobs)
 		wg.Wait()
 		return err
@@ -71,14 +142,19 @@ func (s *driverLocationService) BulkCreate(ctx context.Context, reader io.Reader
 
 	close(jobs)
 	wg.Wait()
-	return nil
+	return ctx.Err() // batches written after a cancellation fail
 }
 
-func (s *driverLocationService) FindNearest(ctx context.Context, lon, lat float64) (*domain.DriverLocation, error) {
+func (s *driverLocationService) FindNearest(ctx context.Context, lon, lat float64, f domain.LocationFilter) (*domain.DriverLocation, error) {
+	filter, err := s.resolveFilter(f)
+	if err != nil {
+		return nil, err
+	}
+
 	var result *domain.DriverLocation
 
-	err := s.breaker.Execute(func() error {
-		dl, err := s.repo.FindNearest(ctx, lon, lat)
+	err = s.breaker.ExecuteContext(ctx, func(ctx context.Context) error {
+		dl, err := s.repo.FindNearest(ctx, lon, lat, filter)
 		if err != nil {
 			return err
 		}
@@ -93,83 +169,353 @@ func (s *driverLocationService) FindNearest(ctx context.Context, lon, lat float6
 	return result, nil
 }
 
-func (s *driverLocationService) startWorker(ctx context.Context, wg *sync.WaitGroup, jobs <-chan []*domain.DriverLocation) {
-	defer wg.Done()
-	for batch := range jobs {
-		log.Printf("bulk started batch %+v", batch)
-		if err := s.repo.BulkCreate(ctx, batch); err != nil {
-			log.Printf("batch import error: %v", err)
-		}
+func (s *driverLocationService) FindKNearest(ctx context.Context, q domain.NearQuery) ([]*domain.DriverDistance, error) {
+	if err := validateCoords(q.Latitude, q.Longitude); err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidArgument, err)
+	}
+	if q.Limit < 1 || q.Limit > maxNearestLimit {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", domain.ErrInvalidArgument, maxNearestLimit)
+	}
+	if q.MaxDistance < 0 {
+		return nil, fmt.Errorf("%w: maxDistance must not be negative", domain.ErrInvalidArgument)
+	}
+
+	filter, err := s.resolveFilter(q.Filter)
+	if err != nil {
+		return nil, err
+	}
+	q.Filter = filter
+
+	return s.findNear(ctx, q)
+}
+
+func (s *driverLocationService) FindWithinRadius(ctx context.Context, q domain.NearQuery) ([]*domain.DriverDistance, error) {
+	if err := validateCoords(q.Latitude, q.Longitude); err != nil {
+		return nil, fmt.Errorf("%w: %v", domain.ErrInvalidArgument, err)
+	}
+	if q.MaxDistance <= 0 {
+		return nil, fmt.Errorf("%w: radius must be positive", domain.ErrInvalidArgument)
+	}
+	if q.Limit < 1 || q.Limit > maxPageSize {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", domain.ErrInvalidArgument, maxPageSize)
+	}
+	if q.Skip < 0 {
+		return nil, fmt.Errorf("%w: page must be positive", domain.ErrInvalidArgument)
+	}
+
+	filter, err := s.resolveFilter(q.Filter)
+	if err != nil {
+		return nil, err
 	}
+	q.Filter = filter
+
+	return s.findNear(ctx, q)
 }
 
-func (s *driverLocationService) produceBatches(r *csv.Reader, jobs chan<- []*domain.DriverLocation) error {
-	now := time.Now().UTC()
-	var batch []*domain.DriverLocation
-	row := 0
-	for {
-		record, err := r.Read()
-		if err == io.EOF {
-			break
+func (s *driverLocationService) FindWithin(ctx context.Context, q domain.WithinQuery) ([]*domain.DriverLocation, error) {
+	switch {
+	case q.Box != nil && q.Polygon != nil:
+		return nil, fmt.Errorf("%w: either a bounding box or a polygon must be given, not both", domain.ErrInvalidArgument)
+	case q.Box != nil:
+		if err := validateBox(*q.Box); err != nil {
+			return nil, fmt.Errorf("%w: %v", domain.ErrInvalidArgument, err)
 		}
-		if err != nil {
-			log.Printf("csv read error: %v", err)
-			continue
+	case q.Polygon != nil:
+		if err := validatePolygon(*q.Polygon); err != nil {
+			return nil, fmt.Errorf("%w: %v", domain.ErrInvalidArgument, err)
 		}
+	default:
+		return nil, fmt.Errorf("%w: a bounding box or a polygon is required", domain.ErrInvalidArgument)
+	}
+	if q.Limit < 1 || q.Limit > maxPageSize {
+		return nil, fmt.Errorf("%w: limit must be between 1 and %d", domain.ErrInvalidArgument, maxPageSize)
+	}
+	if q.Skip < 0 {
+		return nil, fmt.Errorf("%w: page must be positive", domain.ErrInvalidArgument)
+	}
 
-		row++
-		dl, err := toDriverLocation(record, now, row)
+	filter, err := s.resolveFilter(q.Filter)
+	if err != nil {
+		return nil, err
+	}
+	q.Filter = filter
+
+	var result []*domain.DriverLocation
+
+	err = s.breaker.ExecuteContext(ctx, func(ctx context.Context) error {
+		dls, err := s.repo.FindWithin(ctx, q)
 		if err != nil {
-			log.Printf("parse record error: %v", err)
-			continue
+			return err
 		}
+		result = dls
+		return nil
+	})
 
-		batch = append(batch, dl)
-		if len(batch) >= s.batchSize {
-			jobs <- batch
-			batch = nil
-		}
-	}
-	if len(batch) > 0 {
-		jobs <- batch
+	if err != nil {
+		return nil, err
 	}
-	return nil
+
+	return result, nil
 }
 
-func toDriverLocation(record []string, updated time.Time, row int) (*domain.DriverLocation, error) {
-	if len(record) < 2 {
-		return nil, errors.New("invalid record length")
+// Trajectory returns the pings of a driver in the range. A range holding more
+// than maxTrajectoryPoints pings is rejected rather than cut short, so a
+// partial track is never taken for the complete one.
+func (s *driverLocationService) Trajectory(ctx context.Context, q domain.HistoryQuery) ([]*domain.DriverLocation, error) {
+	if !q.From.Before(q.To) {
+		return nil, fmt.Errorf("%w: from must be before to", domain.ErrInvalidArgument)
 	}
-	lat, err := strconv.ParseFloat(record[0], 64)
+	q.Limit = maxTrajectoryPoints + 1
+
+	var result []*domain.DriverLocation
+
+	err := s.breaker.ExecuteContext(ctx, func(ctx context.Context) error {
+		dls, err := s.repo.FindHistory(ctx, q)
+		if err != nil {
+			return err
+		}
+		result = dls
+		return nil
+	})
 
 	if err != nil {
 		return nil, err
 	}
+	if len(result) > maxTrajectoryPoints {
+		last := result[maxTrajectoryPoints-1].Updated.UTC().Format(time.RFC3339)
+		return nil, fmt.Errorf("%w: the range holds more than %d points, the first %d end at %s",
+			domain.ErrInvalidArgument, maxTrajectoryPoints, maxTrajectoryPoints, last)
+	}
+
+	return result, nil
+}
+
+// resolveFilter applies the freshness window of the query, or the service
+// default when the query does not set one.
+func (s *driverLocationService) resolveFilter(f domain.LocationFilter) (domain.LocationFilter, error) {
+	if f.MaxAge < 0 {
+		return f, fmt.Errorf("%w: maxAge must not be negative", domain.ErrInvalidArgument)
+	}
+	for _, status := range f.Statuses {
+		if !status.Valid() {
+			return f, fmt.Errorf("%w: invalid status %q", domain.ErrInvalidArgument, status)
+		}
+	}
+
+	maxAge := f.MaxAge
+	if maxAge == 0 {
+		maxAge = s.maxAge
+	}
+	if maxAge > 0 {
+		f.UpdatedAfter = time.Now().UTC().Add(-maxAge)
+	}
+	return f, nil
+}
+
+func (s *driverLocationService) findNear(ctx context.Context, q domain.NearQuery) ([]*domain.DriverDistance, error) {
+	var result []*domain.DriverDistance
+
+	err := s.breaker.ExecuteContext(ctx, func(ctx context.Context) error {
+		dls, err := s.repo.FindNear(ctx, q)
+		if err != nil {
+			return err
+		}
+		result = dls
+		return nil
+	})
 
-	lon, err := strconv.ParseFloat(record[1], 64)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := validateCoords(lat, lon); err != nil {
-		return nil, fmt.Errorf("validation error on row %d: %w", row, err)
+	return result, nil
+}
+
+func (s *driverLocationService) startWorker(ctx context.Context, wg *sync.WaitGroup, jobs <-chan *importBatch, tuner *writeTuner, progress *importProgress) {
+	defer wg.Done()
+	for batch := range jobs {
+		attempts, err := s.writeBatch(ctx, batch, tuner)
+		if err == nil {
+			progress.commit(batch)
+			continue
+		}
+
+		log.Printf("batch import error: %v", err)
+		if attempts > 1 {
+			err = fmt.Errorf("after %d attempts: %w", attempts, err)
+		}
+		progress.rejectBatch(batch, err)
+		s.deadLetter(ctx, batch, attempts, err, progress)
 	}
+}
+
+// writeBatch writes the batch, retrying transient errors with exponential
+// backoff. Retrying is safe because rows already stored are deduplicated.
+func (s *driverLocationService) writeBatch(ctx context.Context, batch *importBatch, tuner *writeTuner) (int, error) {
+	for attempt := 1; ; attempt++ {
+		if err := tuner.acquire(ctx); err != nil {
+			return attempt - 1, err
+		}
+		start := time.Now()
+		duplicates, err := s.repo.BulkCreate(ctx, batch.locations)
+		tuner.release(time.Since(start), err != nil)
+		batch.duplicates = duplicates
 
-	return &domain.DriverLocation{
-		DriverID: row,
-		Location: domain.GeoJSONPoint{
-			Type:        "Point",
-			Coordinates: []float64{lon, lat},
-		},
-		Updated: updated,
-	}, nil
+		if err == nil || !errors.Is(err, domain.ErrTransient) || attempt >= s.writeAttempts {
+			return attempt, err
+		}
+
+		timer := time.NewTimer(retryDelay(s.retryDelay, attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, err
+		}
+	}
+}
+
+// deadLetter keeps a batch that could not be written. Batches of cancelled
+// imports are not kept, they are written again when the import is resumed.
+func (s *driverLocationService) deadLetter(ctx context.Context, batch *importBatch, attempts int, err error, progress *importProgress) {
+	if s.deadLetters == nil || ctx.Err() != nil {
+		return
+	}
+	letter := &domain.DeadLetter{
+		JobID:     progress.jobID,
+		Rows:      batch.rows,
+		Locations: batch.locations,
+		Attempts:  attempts,
+		Error:     err.Error(),
+		FailedAt:  time.Now().UTC(),
+	}
+	if err := s.deadLetters.Add(ctx, letter); err != nil {
+		log.Printf("dead letter error: %v", err)
+	}
+}
+
+// produceBatches reads the locations into batches for the workers. Rows up to
+// the checkpoint of a resumed import are skipped.
+func (s *driverLocationService) produceBatches(ctx context.Context, r locationReader, jobs chan<- *importBatch, tuner *writeTuner, progress *importProgress, flushAfter time.Duration) error {
+	skip := progress.lastCheckpoint()
+	var seq, row int64
+	batch := &importBatch{}
+
+	// a partial batch is flushed when the timer fires, which requires
+	// reading the rows in the background
+	var rows <-chan readRow
+	var flush <-chan time.Time
+	var timer *time.Timer
+	if flushAfter > 0 {
+		rows = feedRows(ctx, r)
+		timer = time.NewTimer(flushAfter)
+		timer.Stop()
+		defer timer.Stop()
+	}
+
+	send := func() error {
+		if timer != nil {
+			timer.Stop()
+			flush = nil
+		}
+		batch.seq, batch.end = seq, row
+		select {
+		case jobs <- batch:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		seq++
+		batch = &importBatch{}
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var dl *domain.DriverLocation
+		var err error
+		if rows == nil {
+			dl, err = r.Next()
+		} else {
+			select {
+			case next := <-rows:
+				dl, err = next.location, next.err
+			case <-flush:
+				if err := send(); err != nil {
+					return err
+				}
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		row++
+		var rowErr *rowError
+		if err != nil && !errors.As(err, &rowErr) {
+			return err
+		}
+		if row <= skip {
+			continue
+		}
+		progress.rowsRead.Add(1)
+		if rowErr != nil {
+			progress.reject(row, rowErr.Error())
+			continue
+		}
+
+		batch.add(row, dl)
+		if len(batch.locations) >= tuner.currentBatchSize() {
+			if err := send(); err != nil {
+				return err
+			}
+		} else if timer != nil && len(batch.locations) == 1 {
+			timer.Reset(flushAfter)
+			flush = timer.C
+		}
+	}
+	if len(batch.locations) > 0 {
+		return send()
+	}
+	return nil
+}
+
+// readRow is a location read in the background, or the error reading it.
+type readRow struct {
+	location *domain.DriverLocation
+	err      error
+}
+
+// feedRows reads the locations in the background until the end of the input
+// or the first error that is not a rejected row.
+func feedRows(ctx context.Context, r locationReader) <-chan readRow {
+	rows := make(chan readRow)
+	go func() {
+		for {
+			dl, err := r.Next()
+			select {
+			case rows <- readRow{location: dl, err: err}:
+			case <-ctx.Done():
+				return
+			}
+			var rowErr *rowError
+			if err != nil && !errors.As(err, &rowErr) {
+				return
+			}
+		}
+	}()
+	return rows
 }
 
 func validateCoords(lat, lon float64) error {
-	if lat < -180 || lat > 180 {
+	if lat < -90 || lat > 90 {
 		return fmt.Errorf("latitude out of range: %v", lat)
 	}
-	if lon < -90 || lon > 90 {
+	if lon < -180 || lon > 180 {
 		return fmt.Errorf("longitude out of range: %v", lon)
 	}
 	return nil
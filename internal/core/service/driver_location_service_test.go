@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"github.com/envercigal/golang/internal/core/domain"
+	"github.com/envercigal/golang/internal/core/port"
 	circuitbreaker "github.com/envercigal/golang/pkg"
+	"io"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,20 +17,40 @@ import (
 // mockRepo implements port.DriverLocationRepository
 type mockRepo struct {
 	createFn      func(ctx context.Context, dl *domain.DriverLocation) (*domain.DriverLocation, error)
-	bulkCreateFn  func(ctx context.Context, dls []*domain.DriverLocation) error
-	findNearestFn func(ctx context.Context, lon, lat float64) (*domain.DriverLocation, error)
+	bulkCreateFn  func(ctx context.Context, dls []*domain.DriverLocation) (int, error)
+	findNearestFn func(ctx context.Context, lon, lat float64, f domain.LocationFilter) (*domain.DriverLocation, error)
+	findNearFn    func(ctx context.Context, q domain.NearQuery) ([]*domain.DriverDistance, error)
+	findWithinFn  func(ctx context.Context, q domain.WithinQuery) ([]*domain.DriverLocation, error)
+	findHistoryFn func(ctx context.Context, q domain.HistoryQuery) ([]*domain.DriverLocation, error)
+	exportFn      func(ctx context.Context, q domain.ExportQuery) (port.LocationIterator, error)
+}
+
+func (m *mockRepo) Export(ctx context.Context, q domain.ExportQuery) (port.LocationIterator, error) {
+	return m.exportFn(ctx, q)
 }
 
 func (m *mockRepo) Create(ctx context.Context, dl *domain.DriverLocation) (*domain.DriverLocation, error) {
 	return m.createFn(ctx, dl)
 }
 
-func (m *mockRepo) BulkCreate(ctx context.Context, dls []*domain.DriverLocation) error {
+func (m *mockRepo) BulkCreate(ctx context.Context, dls []*domain.DriverLocation) (int, error) {
 	return m.bulkCreateFn(ctx, dls)
 }
 
-func (m *mockRepo) FindNearest(ctx context.Context, lon, lat float64) (*domain.DriverLocation, error) {
-	return m.findNearestFn(ctx, lon, lat)
+func (m *mockRepo) FindNearest(ctx context.Context, lon, lat float64, f domain.LocationFilter) (*domain.DriverLocation, error) {
+	return m.findNearestFn(ctx, lon, lat, f)
+}
+
+func (m *mockRepo) FindNear(ctx context.Context, q domain.NearQuery) ([]*domain.DriverDistance, error) {
+	return m.findNearFn(ctx, q)
+}
+
+func (m *mockRepo) FindWithin(ctx context.Context, q domain.WithinQuery) ([]*domain.DriverLocation, error) {
+	return m.findWithinFn(ctx, q)
+}
+
+func (m *mockRepo) FindHistory(ctx context.Context, q domain.HistoryQuery) ([]*domain.DriverLocation, error) {
+	return m.findHistoryFn(ctx, q)
 }
 
 func TestCreate_ValidCoordinates(t *testing.T) {
@@ -68,24 +91,607 @@ func TestCreate_InvalidLat(t *testing.T) {
 func TestFindNearest_Success(t *testing.T) {
 	expected := &domain.DriverLocation{DriverID: 42}
 	repo := &mockRepo{
-		findNearestFn: func(ctx context.Context, lon, lat float64) (*domain.DriverLocation, error) {
+		findNearestFn: func(ctx context.Context, lon, lat float64, f domain.LocationFilter) (*domain.DriverLocation, error) {
 			return expected, nil
 		},
 	}
 	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
-	got, err := svc.FindNearest(context.Background(), 29, 41)
+	got, err := svc.FindNearest(context.Background(), 29, 41, domain.LocationFilter{})
 	assert.NoError(t, err)
 	assert.Equal(t, expected, got)
 }
 
 func TestFindNearest_NotFound(t *testing.T) {
 	repo := &mockRepo{
-		findNearestFn: func(ctx context.Context, lon, lat float64) (*domain.DriverLocation, error) {
+		findNearestFn: func(ctx context.Context, lon, lat float64, f domain.LocationFilter) (*domain.DriverLocation, error) {
 			return nil, errors.New("no documents")
 		},
 	}
 	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
-	got, err := svc.FindNearest(context.Background(), 29, 41)
+	got, err := svc.FindNearest(context.Background(), 29, 41, domain.LocationFilter{})
 	assert.Error(t, err)
 	assert.Nil(t, got)
 }
+
+func TestFindNearest_NotFoundKeepsBreakerClosed(t *testing.T) {
+	repo := &mockRepo{
+		findNearestFn: func(ctx context.Context, lon, lat float64, f domain.LocationFilter) (*domain.DriverLocation, error) {
+			return nil, domain.ErrNotFound
+		},
+	}
+	breaker := circuitbreaker.New(5, time.Hour, circuitbreaker.WithIsSuccessful(func(err error) bool {
+		return errors.Is(err, domain.ErrNotFound)
+	}))
+	svc := NewDriverLocationService(repo, breaker)
+
+	for i := 0; i < 10; i++ {
+		_, err := svc.FindNearest(context.Background(), 29, 41, domain.LocationFilter{})
+		assert.ErrorIs(t, err, domain.ErrNotFound)
+	}
+	assert.Equal(t, circuitbreaker.Closed, breaker.State())
+}
+
+func TestFindNearest_Timeout(t *testing.T) {
+	repo := &mockRepo{
+		findNearestFn: func(ctx context.Context, lon, lat float64, f domain.LocationFilter) (*domain.DriverLocation, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	breaker := circuitbreaker.New(1, time.Hour, circuitbreaker.WithTimeout(10*time.Millisecond))
+	svc := NewDriverLocationService(repo, breaker)
+
+	_, err := svc.FindNearest(context.Background(), 29, 41, domain.LocationFilter{})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	_, err = svc.FindNearest(context.Background(), 29, 41, domain.LocationFilter{})
+	assert.ErrorIs(t, err, circuitbreaker.ErrOpen)
+}
+
+func TestFindKNearest_Success(t *testing.T) {
+	expected := []*domain.DriverDistance{
+		{DriverLocation: domain.DriverLocation{DriverID: 1}, Distance: 10},
+		{DriverLocation: domain.DriverLocation{DriverID: 2}, Distance: 20},
+	}
+	repo := &mockRepo{
+		findNearFn: func(ctx context.Context, q domain.NearQuery) ([]*domain.DriverDistance, error) {
+			assert.Equal(t, 2, q.Limit)
+			assert.Equal(t, 1000.0, q.MaxDistance)
+			return expected, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+	got, err := svc.FindKNearest(context.Background(), domain.NearQuery{Longitude: 29, Latitude: 41, Limit: 2, MaxDistance: 1000})
+	assert.NoError(t, err)
+	assert.Equal(t, expected, got)
+}
+
+func TestFindKNearest_InvalidLimit(t *testing.T) {
+	svc := NewDriverLocationService(&mockRepo{}, circuitbreaker.New(5, 1))
+	_, err := svc.FindKNearest(context.Background(), domain.NearQuery{Longitude: 29, Latitude: 41, Limit: 0})
+	assert.ErrorIs(t, err, domain.ErrInvalidArgument)
+}
+
+func TestFindWithinRadius_RequiresRadius(t *testing.T) {
+	svc := NewDriverLocationService(&mockRepo{}, circuitbreaker.New(5, 1))
+	_, err := svc.FindWithinRadius(context.Background(), domain.NearQuery{Longitude: 29, Latitude: 41, Limit: 10})
+	assert.ErrorIs(t, err, domain.ErrInvalidArgument)
+}
+
+func TestFindWithin_BoxIsPassedThrough(t *testing.T) {
+	repo := &mockRepo{
+		findWithinFn: func(ctx context.Context, q domain.WithinQuery) ([]*domain.DriverLocation, error) {
+			assert.Equal(t, &domain.BoundingBox{MinLon: 28.9, MinLat: 40.9, MaxLon: 29.1, MaxLat: 41.1}, q.Box)
+			assert.Nil(t, q.Polygon)
+			return []*domain.DriverLocation{{DriverID: 1}}, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+	got, err := svc.FindWithin(context.Background(), domain.WithinQuery{
+		Box:   &domain.BoundingBox{MinLon: 28.9, MinLat: 40.9, MaxLon: 29.1, MaxLat: 41.1},
+		Limit: 10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, got, 1)
+}
+
+func TestFindWithin_AcceptsHemisphereWideBoxes(t *testing.T) {
+	repo := &mockRepo{
+		findWithinFn: func(ctx context.Context, q domain.WithinQuery) ([]*domain.DriverLocation, error) {
+			return nil, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+	for _, box := range []domain.BoundingBox{
+		{MinLon: -180, MinLat: -60, MaxLon: 180, MaxLat: 60},
+		{MinLon: -90, MinLat: 0, MaxLon: 90, MaxLat: 10},
+	} {
+		_, err := svc.FindWithin(context.Background(), domain.WithinQuery{Box: &box, Limit: 10})
+		assert.NoError(t, err)
+	}
+}
+
+func TestFindWithin_InvalidPolygons(t *testing.T) {
+	cases := map[string][][]float64{
+		"unclosed":       {{0, 0}, {1, 0}, {1, 1}, {0, 1}},
+		"too few points": {{0, 0}, {1, 0}, {0, 0}},
+		"bow tie":        {{0, 0}, {1, 1}, {1, 0}, {0, 1}, {0, 0}},
+		"folds back":     {{0, 0}, {2, 0}, {1, 0}, {1, 1}, {0, 0}},
+		"out of range":   {{0, 0}, {0, 95}, {1, 95}, {0, 0}},
+	}
+	svc := NewDriverLocationService(&mockRepo{}, circuitbreaker.New(5, 1))
+	for name, ring := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, err := svc.FindWithin(context.Background(), domain.WithinQuery{
+				Polygon: &domain.GeoJSONPolygon{Type: "Polygon", Coordinates: [][][]float64{ring}},
+				Limit:   10,
+			})
+			assert.ErrorIs(t, err, domain.ErrInvalidArgument)
+		})
+	}
+}
+
+func TestFindWithin_ValidPolygon(t *testing.T) {
+	repo := &mockRepo{
+		findWithinFn: func(ctx context.Context, q domain.WithinQuery) ([]*domain.DriverLocation, error) {
+			return []*domain.DriverLocation{}, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+	_, err := svc.FindWithin(context.Background(), domain.WithinQuery{
+		Polygon: &domain.GeoJSONPolygon{Type: "Polygon", Coordinates: [][][]float64{
+			{{0, 0}, {2, 0}, {2, 2}, {1, 3}, {0, 2}, {0, 0}},
+		}},
+		Limit: 10,
+	})
+	assert.NoError(t, err)
+}
+
+func TestTrajectory_InvalidRange(t *testing.T) {
+	now := time.Now()
+	svc := NewDriverLocationService(&mockRepo{}, circuitbreaker.New(5, 1))
+	_, err := svc.Trajectory(context.Background(), domain.HistoryQuery{DriverID: 1, From: now, To: now.Add(-time.Hour)})
+	assert.ErrorIs(t, err, domain.ErrInvalidArgument)
+}
+
+func TestTrajectory_RejectsRangesOverTheCap(t *testing.T) {
+	start := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	points := 0
+	repo := &mockRepo{
+		findHistoryFn: func(ctx context.Context, q domain.HistoryQuery) ([]*domain.DriverLocation, error) {
+			dls := make([]*domain.DriverLocation, min(points, q.Limit))
+			for i := range dls {
+				dls[i] = &domain.DriverLocation{DriverID: 1, Updated: start.Add(time.Duration(i) * time.Second)}
+			}
+			return dls, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+	q := domain.HistoryQuery{DriverID: 1, From: start, To: start.Add(24 * time.Hour)}
+
+	points = maxTrajectoryPoints
+	got, err := svc.Trajectory(context.Background(), q)
+	assert.NoError(t, err)
+	assert.Len(t, got, maxTrajectoryPoints)
+
+	points = maxTrajectoryPoints + 1
+	_, err = svc.Trajectory(context.Background(), q)
+	assert.ErrorIs(t, err, domain.ErrInvalidArgument)
+	assert.ErrorContains(t, err, "2025-03-01T02:46:39Z")
+}
+
+func TestFindNearest_AppliesDefaultMaxAge(t *testing.T) {
+	var got domain.LocationFilter
+	repo := &mockRepo{
+		findNearestFn: func(ctx context.Context, lon, lat float64, f domain.LocationFilter) (*domain.DriverLocation, error) {
+			got = f
+			return &domain.DriverLocation{}, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1), WithMaxAge(10*time.Minute))
+	_, err := svc.FindNearest(context.Background(), 29, 41, domain.LocationFilter{})
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(-10*time.Minute), got.UpdatedAfter, time.Second)
+}
+
+func TestFindNearest_RejectsInvalidStatus(t *testing.T) {
+	svc := NewDriverLocationService(&mockRepo{}, circuitbreaker.New(5, 1))
+	_, err := svc.FindNearest(context.Background(), 29, 41, domain.LocationFilter{
+		Statuses: []domain.DriverStatus{"asleep"},
+	})
+	assert.ErrorIs(t, err, domain.ErrInvalidArgument)
+}
+
+func TestFindKNearest_QueryMaxAgeOverridesDefault(t *testing.T) {
+	var got domain.LocationFilter
+	repo := &mockRepo{
+		findNearFn: func(ctx context.Context, q domain.NearQuery) ([]*domain.DriverDistance, error) {
+			got = q.Filter
+			return nil, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+	_, err := svc.FindKNearest(context.Background(), domain.NearQuery{
+		Longitude: 29, Latitude: 41, Limit: 5,
+		Filter: domain.LocationFilter{MaxAge: time.Minute},
+	})
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(-time.Minute), got.UpdatedAfter, time.Second)
+}
+
+func TestFindNearest_MaxAgeDisabled(t *testing.T) {
+	repo := &mockRepo{
+		findNearestFn: func(ctx context.Context, lon, lat float64, f domain.LocationFilter) (*domain.DriverLocation, error) {
+			assert.True(t, f.UpdatedAfter.IsZero())
+			return &domain.DriverLocation{}, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1), WithMaxAge(0))
+	_, err := svc.FindNearest(context.Background(), 29, 41, domain.LocationFilter{})
+	assert.NoError(t, err)
+}
+
+func TestCreate_DefaultsStatusToAvailable(t *testing.T) {
+	repo := &mockRepo{
+		createFn: func(ctx context.Context, dl *domain.DriverLocation) (*domain.DriverLocation, error) {
+			return dl, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+	created, err := svc.Create(context.Background(), &domain.DriverLocation{
+		DriverID: 1,
+		Location: domain.GeoJSONPoint{Type: "Point", Coordinates: []float64{29.0, 41.0}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, domain.StatusAvailable, created.Status)
+}
+
+func TestCreate_InvalidStatus(t *testing.T) {
+	svc := NewDriverLocationService(&mockRepo{}, circuitbreaker.New(5, 1))
+	_, err := svc.Create(context.Background(), &domain.DriverLocation{
+		DriverID: 1,
+		Location: domain.GeoJSONPoint{Type: "Point", Coordinates: []float64{29.0, 41.0}},
+		Status:   "sleeping",
+	})
+	assert.Error(t, err)
+}
+
+func TestFindKNearest_InvalidStatusFilter(t *testing.T) {
+	svc := NewDriverLocationService(&mockRepo{}, circuitbreaker.New(5, 1))
+	_, err := svc.FindKNearest(context.Background(), domain.NearQuery{
+		Longitude: 29, Latitude: 41, Limit: 5,
+		Filter: domain.LocationFilter{Statuses: []domain.DriverStatus{"sleeping"}},
+	})
+	assert.ErrorIs(t, err, domain.ErrInvalidArgument)
+}
+
+func waitForJob(t *testing.T, svc port.DriverLocationService, id string) *domain.ImportJob {
+	t.Helper()
+	var job *domain.ImportJob
+	assert.Eventually(t, func() bool {
+		var err error
+		job, err = svc.ImportJob(context.Background(), id)
+		return err == nil && job.Finished()
+	}, 2*time.Second, 5*time.Millisecond)
+	return job
+}
+
+func TestStartImport_ReportsProgress(t *testing.T) {
+	repo := &mockRepo{
+		bulkCreateFn: func(ctx context.Context, dls []*domain.DriverLocation) (int, error) {
+			return 0, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+
+	csv := "driver_id,lat,lon\n1,41,29\n2,41.1,29.1\n3,999,29\n4,41.2,29.2\n"
+	job, err := svc.StartImport(context.Background(), io.NopCloser(strings.NewReader(csv)), domain.ImportOptions{})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, job.ID)
+
+	job = waitForJob(t, svc, job.ID)
+	assert.Equal(t, domain.ImportCompleted, job.Status)
+	assert.Equal(t, int64(4), job.RowsRead)
+	assert.Equal(t, int64(3), job.Inserted)
+	assert.Equal(t, int64(1), job.Rejected)
+	assert.Equal(t, int64(0), job.FailedBatches)
+	assert.NotNil(t, job.FinishedAt)
+	assert.Equal(t, []domain.RowError{{Row: 3, Reason: "latitude out of range: 999"}}, job.Report.Errors)
+}
+
+func TestBulkCreate_ReportsRejectedRows(t *testing.T) {
+	repo := &mockRepo{
+		bulkCreateFn: func(ctx context.Context, dls []*domain.DriverLocation) (int, error) {
+			return 0, errors.New("write failed")
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+
+	report, err := svc.BulkCreate(context.Background(), strings.NewReader("driver_id,lat,lon\n1,41,29\n2,abc,29\n"), domain.ImportOptions{Format: domain.FormatCSV})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), report.Accepted)
+	assert.Equal(t, int64(2), report.Rejected)
+	assert.Len(t, report.Errors, 2)
+	assert.Equal(t, int64(1), report.Errors[0].Row)
+	assert.Equal(t, "batch insert failed: write failed", report.Errors[0].Reason)
+	assert.Equal(t, int64(2), report.Errors[1].Row)
+}
+
+func TestBulkCreate_ReportsDuplicates(t *testing.T) {
+	repo := &mockRepo{
+		bulkCreateFn: func(ctx context.Context, dls []*domain.DriverLocation) (int, error) {
+			return 1, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+
+	csv := "driver_id,lat,lon,timestamp\n1,41,29,2025-03-01T10:00:00Z\n2,41,29,2025-03-01T10:00:00Z\n"
+	report, err := svc.BulkCreate(context.Background(), strings.NewReader(csv), domain.ImportOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), report.Accepted)
+	assert.Equal(t, int64(1), report.Duplicates)
+	assert.Equal(t, int64(0), report.Rejected)
+}
+
+func TestBulkCreate_KeepsUntimedRowsOfADriverApart(t *testing.T) {
+	var stored []*domain.DriverLocation
+	repo := &mockRepo{
+		bulkCreateFn: func(ctx context.Context, dls []*domain.DriverLocation) (int, error) {
+			stored = append(stored, dls...)
+			return 0, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+
+	csv := "driver_id,lat,lon,timestamp\n1,41,29,\n1,41.1,29.1,\n2,41,29,\n1,41.2,29.2,\n1,41.3,29.3,2025-03-01T10:00:00Z\n"
+	report, err := svc.BulkCreate(context.Background(), strings.NewReader(csv), domain.ImportOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), report.Accepted)
+
+	assert.Len(t, stored, 5)
+	stamp := stored[0].Updated
+	assert.Equal(t, stamp.Truncate(time.Millisecond), stamp)
+	assert.Equal(t, stamp.Add(time.Millisecond), stored[1].Updated)
+	assert.Equal(t, stamp, stored[2].Updated)
+	assert.Equal(t, stamp.Add(2*time.Millisecond), stored[3].Updated)
+	assert.Equal(t, time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC), stored[4].Updated)
+}
+
+func TestStartImport_Cancel(t *testing.T) {
+	started := make(chan struct{})
+	repo := &mockRepo{
+		bulkCreateFn: func(ctx context.Context, dls []*domain.DriverLocation) (int, error) {
+			close(started)
+			<-ctx.Done()
+			return 0, ctx.Err()
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+
+	job, err := svc.StartImport(context.Background(), io.NopCloser(strings.NewReader("driver_id,lat,lon\n1,41,29\n")), domain.ImportOptions{})
+	assert.NoError(t, err)
+	<-started
+
+	_, err = svc.CancelImport(context.Background(), job.ID)
+	assert.NoError(t, err)
+
+	job = waitForJob(t, svc, job.ID)
+	assert.Equal(t, domain.ImportCancelled, job.Status)
+}
+
+func TestImportJob_NotFound(t *testing.T) {
+	svc := NewDriverLocationService(&mockRepo{}, circuitbreaker.New(5, 1))
+	_, err := svc.ImportJob(context.Background(), "missing")
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+}
+
+func TestBulkCreate_GeoJSON(t *testing.T) {
+	var stored []*domain.DriverLocation
+	repo := &mockRepo{
+		bulkCreateFn: func(ctx context.Context, dls []*domain.DriverLocation) (int, error) {
+			stored = append(stored, dls...)
+			return 0, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+
+	geojson := `{
+		"type": "FeatureCollection",
+		"name": "drivers",
+		"features": [
+			{"type": "Feature", "geometry": {"type": "Point", "coordinates": [29.0, 41.0]},
+			 "properties": {"driver_id": 12, "timestamp": "2025-03-01T10:00:00Z", "status": "on_trip", "vehicle_type": "van"}},
+			{"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[29, 41], [30, 42]]},
+			 "properties": {"driver_id": 13}},
+			{"type": "Feature", "geometry": {"type": "Point", "coordinates": [29.1, 41.1]},
+			 "properties": {"status": "on_trip"}},
+			{"type": "Feature", "geometry": {"type": "Point", "coordinates": "29,41"},
+			 "properties": {"driver_id": 14}},
+			{"type": "Feature", "geometry": {"type": "Point", "coordinates": [29.2, 41.2]},
+			 "properties": {"driver_id": "15", "timestamp": 1740823200}}
+		]
+	}`
+	report, err := svc.BulkCreate(context.Background(), strings.NewReader(geojson), domain.ImportOptions{Format: domain.FormatGeoJSON})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), report.Accepted)
+	assert.Equal(t, int64(3), report.Rejected)
+	assert.Equal(t, []int64{2, 3, 4}, []int64{report.Errors[0].Row, report.Errors[1].Row, report.Errors[2].Row})
+
+	assert.Len(t, stored, 2)
+	assert.Equal(t, &domain.DriverLocation{
+		DriverID:    12,
+		Location:    domain.GeoJSONPoint{Type: "Point", Coordinates: []float64{29.0, 41.0}},
+		Status:      domain.StatusOnTrip,
+		VehicleType: "van",
+		Updated:     time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC),
+	}, stored[0])
+	assert.Equal(t, 15, stored[1].DriverID)
+	assert.Equal(t, time.Unix(1740823200, 0).UTC(), stored[1].Updated)
+}
+
+func TestBulkCreate_GeoJSONNotAFeatureCollection(t *testing.T) {
+	svc := NewDriverLocationService(&mockRepo{}, circuitbreaker.New(5, 1))
+	_, err := svc.BulkCreate(context.Background(), strings.NewReader(`{"type":"Feature"}`), domain.ImportOptions{Format: domain.FormatGeoJSON})
+	assert.ErrorIs(t, err, domain.ErrInvalidArgument)
+}
+
+func TestBulkCreate_NDJSON(t *testing.T) {
+	var stored []*domain.DriverLocation
+	repo := &mockRepo{
+		bulkCreateFn: func(ctx context.Context, dls []*domain.DriverLocation) (int, error) {
+			stored = append(stored, dls...)
+			return 0, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+
+	stream := `{"driver_id": 7, "location": {"type": "Point", "coordinates": [29.0, 41.0]}, "status": "on_trip", "updated_at": "2025-03-01T10:00:00Z"}
+
+{"driver_id": 8, "location": {"type": "Point", "coordinates": [29.1
+{"location": {"type": "Point", "coordinates": [29.2, 41.2]}}
+{"driver_id": 9, "location": {"type": "Point", "coordinates": [29.3, 141.3]}}
+` + `{"driver_id": 10, "location": {"type": "Point", "coordinates": [29.4, 41.4]}, "pad": "` + strings.Repeat("x", maxNDJSONLine) + `"}
+{"driver_id": 11, "location": {"type": "Point", "coordinates": [29.5, 41.5]}, "vehicle_type": "car"}`
+	report, err := svc.BulkCreate(context.Background(), strings.NewReader(stream), domain.ImportOptions{Format: domain.FormatNDJSON})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), report.Accepted)
+	assert.Equal(t, int64(4), report.Rejected)
+	assert.Equal(t, []int64{2, 3, 4, 5}, []int64{report.Errors[0].Row, report.Errors[1].Row, report.Errors[2].Row, report.Errors[3].Row})
+
+	assert.Len(t, stored, 2)
+	assert.Equal(t, &domain.DriverLocation{
+		DriverID: 7,
+		Location: domain.GeoJSONPoint{Type: "Point", Coordinates: []float64{29.0, 41.0}},
+		Status:   domain.StatusOnTrip,
+		Updated:  time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC),
+	}, stored[0])
+	assert.Equal(t, 11, stored[1].DriverID)
+	assert.Equal(t, domain.StatusAvailable, stored[1].Status)
+	assert.Equal(t, "car", stored[1].VehicleType)
+}
+
+func TestBulkCreate_CSVHeaderMapping(t *testing.T) {
+	var stored []*domain.DriverLocation
+	repo := &mockRepo{
+		bulkCreateFn: func(ctx context.Context, dls []*domain.DriverLocation) (int, error) {
+			stored = append(stored, dls...)
+			return 0, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+
+	csv := "\ufeffVehicle_Type;Longitude;Latitude;Driver_ID;Status;Timestamp\n" +
+		"van;29;41;12;on_trip;2025-03-01T10:00:00Z\n" +
+		";29.1;41.1;x;;\n" +
+		";29.2;41.2;13;sleeping;\n" +
+		";29.3;41.3;14;;1740823200\n"
+	report, err := svc.BulkCreate(context.Background(), strings.NewReader(csv), domain.ImportOptions{
+		Delimiter: ';',
+		Columns:   map[string]string{"lat": "latitude", "lon": "LONGITUDE"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), report.Accepted)
+	assert.Equal(t, int64(2), report.Rejected)
+	assert.Equal(t, "invalid driver_id \"x\"", report.Errors[0].Reason)
+	assert.Equal(t, "invalid status \"sleeping\"", report.Errors[1].Reason)
+
+	assert.Len(t, stored, 2)
+	assert.Equal(t, &domain.DriverLocation{
+		DriverID:    12,
+		Location:    domain.GeoJSONPoint{Type: "Point", Coordinates: []float64{29, 41}},
+		Status:      domain.StatusOnTrip,
+		VehicleType: "van",
+		Updated:     time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC),
+	}, stored[0])
+	assert.Equal(t, 14, stored[1].DriverID)
+	assert.Equal(t, domain.StatusAvailable, stored[1].Status)
+	assert.Equal(t, time.Unix(1740823200, 0).UTC(), stored[1].Updated)
+}
+
+func TestBulkCreate_CSVRejectsInvalidHeader(t *testing.T) {
+	svc := NewDriverLocationService(&mockRepo{}, circuitbreaker.New(5, 1))
+
+	for name, tc := range map[string]struct {
+		csv  string
+		opts domain.ImportOptions
+	}{
+		"empty file":      {csv: ""},
+		"missing columns": {csv: "lat,lon\n41,29\n"},
+		"duplicate":       {csv: "driver_id,lat,lat,lon\n"},
+		"unknown column":  {csv: "driver_id,lat,lon\n", opts: domain.ImportOptions{Columns: map[string]string{"speed": "v"}}},
+		"bad delimiter":   {csv: "driver_id,lat,lon\n", opts: domain.ImportOptions{Delimiter: '"'}},
+		"wrong format":    {csv: "{}", opts: domain.ImportOptions{Format: domain.FormatNDJSON, Delimiter: ';'}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := svc.BulkCreate(context.Background(), strings.NewReader(tc.csv), tc.opts)
+			assert.ErrorIs(t, err, domain.ErrInvalidArgument)
+		})
+	}
+}
+
+func TestStartImport_RejectsMissingColumnsUpFront(t *testing.T) {
+	svc := NewDriverLocationService(&mockRepo{}, circuitbreaker.New(5, 1))
+	_, err := svc.StartImport(context.Background(), io.NopCloser(strings.NewReader("lat,lon\n41,29\n")), domain.ImportOptions{})
+	assert.ErrorContains(t, err, "missing required csv columns: driver_id")
+}
+
+func TestValidateImport(t *testing.T) {
+	repo := &mockRepo{
+		bulkCreateFn: func(ctx context.Context, dls []*domain.DriverLocation) (int, error) {
+			t.Fatal("a dry run must not write")
+			return 0, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+
+	csv := "driver_id,lat,lon,timestamp\n" +
+		"1,41,29,2025-03-01T10:00:00Z\n" +
+		"2,40.5,28.5,2025-03-01T09:00:00Z\n" +
+		"1,41.5,29.5,2025-03-01T11:00:00Z\n" +
+		"3,95,29,2025-03-01T12:00:00Z\n"
+	summary, err := svc.ValidateImport(context.Background(), strings.NewReader(csv), domain.ImportOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), summary.Rows)
+	assert.Equal(t, int64(3), summary.Valid)
+	assert.Equal(t, int64(1), summary.Rejected)
+	assert.Equal(t, []domain.RowError{{Row: 4, Reason: "latitude out of range: 95"}}, summary.Errors)
+	assert.Equal(t, &domain.BoundingBox{MinLon: 28.5, MinLat: 40.5, MaxLon: 29.5, MaxLat: 41.5}, summary.BoundingBox)
+	assert.Equal(t, time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC), *summary.From)
+	assert.Equal(t, time.Date(2025, 3, 1, 11, 0, 0, 0, time.UTC), *summary.To)
+	assert.Equal(t, int64(2), summary.Drivers)
+	assert.Equal(t, []int{1}, summary.DuplicateDriverIDs)
+}
+
+func TestValidateImport_NoValidRows(t *testing.T) {
+	svc := NewDriverLocationService(&mockRepo{}, circuitbreaker.New(5, 1))
+	summary, err := svc.ValidateImport(context.Background(), strings.NewReader("driver_id,lat,lon\n"), domain.ImportOptions{})
+	assert.NoError(t, err)
+	assert.Nil(t, summary.BoundingBox)
+	assert.Nil(t, summary.From)
+	assert.Empty(t, summary.DuplicateDriverIDs)
+}
+
+func TestExport_ValidatesQuery(t *testing.T) {
+	var got domain.ExportQuery
+	repo := &mockRepo{
+		exportFn: func(ctx context.Context, q domain.ExportQuery) (port.LocationIterator, error) {
+			got = q
+			return nil, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+
+	_, err := svc.Export(context.Background(), domain.ExportQuery{})
+	assert.NoError(t, err)
+	assert.Equal(t, domain.SourceCurrent, got.Source)
+
+	now := time.Now()
+	for _, q := range []domain.ExportQuery{
+		{Source: "archive"},
+		{From: now, To: now.Add(-time.Hour)},
+		{Box: &domain.BoundingBox{MinLon: 30, MinLat: 41, MaxLon: 29, MaxLat: 42}},
+		{Statuses: []domain.DriverStatus{"sleeping"}},
+	} {
+		_, err := svc.Export(context.Background(), q)
+		assert.ErrorIs(t, err, domain.ErrInvalidArgument)
+	}
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/envercigal/golang/internal/core/domain"
+	"github.com/envercigal/golang/internal/core/port"
+)
+
+// Export opens a stream of the current positions or the history matching the
+// query. The caller must close the returned iterator.
+func (s *driverLocationService) Export(ctx context.Context, q domain.ExportQuery) (port.LocationIterator, error) {
+	switch q.Source {
+	case "":
+		q.Source = domain.SourceCurrent
+	case domain.SourceCurrent, domain.SourceHistory:
+	default:
+		return nil, fmt.Errorf("%w: source must be current or history", domain.ErrInvalidArgument)
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && q.From.After(q.To) {
+		return nil, fmt.Errorf("%w: from must not be after to", domain.ErrInvalidArgument)
+	}
+	if q.Box != nil {
+		if err := validateBox(*q.Box); err != nil {
+			return nil, fmt.Errorf("%w: %v", domain.ErrInvalidArgument, err)
+		}
+	}
+	for _, status := range q.Statuses {
+		if !status.Valid() {
+			return nil, fmt.Errorf("%w: invalid status %q", domain.ErrInvalidArgument, status)
+		}
+	}
+
+	var result port.LocationIterator
+
+	err := s.breaker.ExecuteContext(ctx, func(ctx context.Context) error {
+		it, err := s.repo.Export(ctx, q)
+		if err != nil {
+			return err
+		}
+		result = it
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
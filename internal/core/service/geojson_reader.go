@@ -0,0 +1,163 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/envercigal/golang/internal/core/domain"
+	"io"
+	"strconv"
+	"time"
+)
+
+// geoJSONLocationReader streams the Point features of a FeatureCollection,
+// decoding one feature at a time so the file never has to fit in memory.
+type geoJSONLocationReader struct {
+	dec  *json.Decoder
+	done bool
+}
+
+type geoJSONFeature struct {
+	Type     string `json:"type"`
+	Geometry *struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	} `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+func newGeoJSONLocationReader(r io.Reader) (*geoJSONLocationReader, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, fmt.Errorf("invalid geojson: %w", err)
+		}
+		switch tok {
+		case "features":
+			if err := expectDelim(dec, '['); err != nil {
+				return nil, err
+			}
+			return &geoJSONLocationReader{dec: dec}, nil
+		case "type":
+			var typ string
+			if err := dec.Decode(&typ); err != nil {
+				return nil, fmt.Errorf("invalid geojson: %w", err)
+			}
+			if typ != "FeatureCollection" {
+				return nil, fmt.Errorf("%w: geojson type must be FeatureCollection, got %q", domain.ErrInvalidArgument, typ)
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, fmt.Errorf("invalid geojson: %w", err)
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: geojson has no features", domain.ErrInvalidArgument)
+}
+
+func (g *geoJSONLocationReader) Next() (*domain.DriverLocation, error) {
+	if g.done || !g.dec.More() {
+		g.done = true
+		return nil, io.EOF
+	}
+
+	var f geoJSONFeature
+	if err := g.dec.Decode(&f); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			// the decoder skipped the mistyped value and can go on
+			return nil, &rowError{err: fmt.Errorf("invalid feature: %w", err)}
+		}
+		return nil, fmt.Errorf("invalid geojson: %w", err)
+	}
+	return featureToDriverLocation(f)
+}
+
+func featureToDriverLocation(f geoJSONFeature) (*domain.DriverLocation, error) {
+	if f.Type != "Feature" {
+		return nil, rejectRow("expected a Feature, got %q", f.Type)
+	}
+	if f.Geometry == nil || f.Geometry.Type != "Point" {
+		return nil, rejectRow("geometry must be a Point")
+	}
+	var coords []float64
+	if err := json.Unmarshal(f.Geometry.Coordinates, &coords); err != nil || len(coords) < 2 {
+		return nil, rejectRow("point must have longitude and latitude")
+	}
+	lon, lat := coords[0], coords[1]
+	if err := validateCoords(lat, lon); err != nil {
+		return nil, &rowError{err: err}
+	}
+
+	driverID, err := intProperty(f.Properties, "driver_id")
+	if err != nil {
+		return nil, &rowError{err: err}
+	}
+
+	var updated time.Time
+	if v, ok := f.Properties["timestamp"]; ok && v != nil {
+		if updated, err = parseTimestamp(fmt.Sprint(v)); err != nil {
+			return nil, &rowError{err: err}
+		}
+	}
+
+	status, err := parseStatus(stringProperty(f.Properties, "status"))
+	if err != nil {
+		return nil, &rowError{err: err}
+	}
+
+	return &domain.DriverLocation{
+		DriverID: driverID,
+		Location: domain.GeoJSONPoint{
+			Type:        "Point",
+			Coordinates: []float64{lon, lat},
+		},
+		Status:      status,
+		VehicleType: stringProperty(f.Properties, "vehicle_type"),
+		Updated:     updated,
+	}, nil
+}
+
+func intProperty(props map[string]interface{}, key string) (int, error) {
+	switch v := props[key].(type) {
+	case json.Number:
+		id, err := strconv.Atoi(v.String())
+		if err != nil {
+			return 0, fmt.Errorf("%s must be an integer: %s", key, v)
+		}
+		return id, nil
+	case string:
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be an integer: %q", key, v)
+		}
+		return id, nil
+	case nil:
+		return 0, fmt.Errorf("missing %s", key)
+	default:
+		return 0, fmt.Errorf("%s must be an integer", key)
+	}
+}
+
+func stringProperty(props map[string]interface{}, key string) string {
+	v, _ := props[key].(string)
+	return v
+}
+
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return fmt.Errorf("invalid geojson: %w", err)
+	}
+	if tok != want {
+		return fmt.Errorf("%w: invalid geojson: expected %q", domain.ErrInvalidArgument, want)
+	}
+	return nil
+}
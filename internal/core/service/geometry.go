@@ -0,0 +1,116 @@
+package service
+
+import (
+	"fmt"
+	"github.com/envercigal/golang/internal/core/domain"
+)
+
+const (
+	// maxPolygonVertices bounds the quadratic self-intersection check.
+	maxPolygonVertices = 1000
+)
+
+func validateBox(b domain.BoundingBox) error {
+	if err := validateCoords(b.MinLat, b.MinLon); err != nil {
+		return err
+	}
+	if err := validateCoords(b.MaxLat, b.MaxLon); err != nil {
+		return err
+	}
+	if b.MinLon >= b.MaxLon || b.MinLat >= b.MaxLat {
+		return fmt.Errorf("bounding box min corner must be south-west of max corner")
+	}
+	return nil
+}
+
+func validatePolygon(p domain.GeoJSONPolygon) error {
+	if p.Type != "Polygon" {
+		return fmt.Errorf("geometry type must be Polygon, got %q", p.Type)
+	}
+	if len(p.Coordinates) == 0 {
+		return fmt.Errorf("polygon has no rings")
+	}
+
+	vertices := 0
+	for i, ring := range p.Coordinates {
+		if len(ring) < 4 {
+			return fmt.Errorf("ring %d must have at least 4 positions", i)
+		}
+		vertices += len(ring)
+		if vertices > maxPolygonVertices {
+			return fmt.Errorf("polygon has more than %d vertices", maxPolygonVertices)
+		}
+		for j, pos := range ring {
+			if len(pos) < 2 {
+				return fmt.Errorf("ring %d position %d must have longitude and latitude", i, j)
+			}
+			if err := validateCoords(pos[1], pos[0]); err != nil {
+				return fmt.Errorf("ring %d position %d: %w", i, j, err)
+			}
+		}
+		first, last := ring[0], ring[len(ring)-1]
+		if first[0] != last[0] || first[1] != last[1] {
+			return fmt.Errorf("ring %d is not closed", i)
+		}
+		if selfIntersects(ring) {
+			return fmt.Errorf("ring %d is self-intersecting", i)
+		}
+	}
+	return nil
+}
+
+// selfIntersects reports whether any two edges of the closed ring touch or
+// cross each other anywhere other than at the vertex adjacent edges share.
+func selfIntersects(ring [][]float64) bool {
+	n := len(ring) - 1 // number of edges
+	for i := 0; i < n; i++ {
+		for j := i + 1; j < n; j++ {
+			switch {
+			case j == i+1:
+				if foldsBack(ring[i], ring[i+1], ring[j+1]) {
+					return true
+				}
+			case i == 0 && j == n-1:
+				if foldsBack(ring[1], ring[0], ring[n-1]) {
+					return true
+				}
+			case segmentsIntersect(ring[i], ring[i+1], ring[j], ring[j+1]):
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func segmentsIntersect(p1, p2, q1, q2 []float64) bool {
+	d1 := orientation(q1, q2, p1)
+	d2 := orientation(q1, q2, p2)
+	d3 := orientation(p1, p2, q1)
+	d4 := orientation(p1, p2, q2)
+
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+	return (d1 == 0 && onSegment(q1, q2, p1)) ||
+		(d2 == 0 && onSegment(q1, q2, p2)) ||
+		(d3 == 0 && onSegment(p1, p2, q1)) ||
+		(d4 == 0 && onSegment(p1, p2, q2))
+}
+
+// foldsBack reports whether the adjacent edges a-v and v-b are collinear and
+// double back over each other, which includes zero-length edges.
+func foldsBack(a, v, b []float64) bool {
+	if orientation(a, v, b) != 0 {
+		return false
+	}
+	return (a[0]-v[0])*(b[0]-v[0])+(a[1]-v[1])*(b[1]-v[1]) >= 0
+}
+
+func orientation(a, b, c []float64) float64 {
+	return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+}
+
+func onSegment(a, b, p []float64) bool {
+	return p[0] >= min(a[0], b[0]) && p[0] <= max(a[0], b[0]) &&
+		p[1] >= min(a[1], b[1]) && p[1] <= max(a[1], b[1])
+}
@@ -0,0 +1,335 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/envercigal/golang/internal/core/domain"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// finishedJobRetention is how long finished jobs stay available for polling.
+	finishedJobRetention = 24 * time.Hour
+
+	// checkpointInterval is how often a running job is saved to the job store.
+	checkpointInterval = 5 * time.Second
+
+	// staleJobAfter is how long a running job may go without a checkpoint
+	// before it is considered dead and can be resumed elsewhere.
+	staleJobAfter = time.Minute
+)
+
+type importJob struct {
+	progress importProgress
+	cancel   context.CancelFunc
+
+	mu         sync.Mutex
+	id         string
+	key        string
+	status     domain.ImportJobStatus
+	err        error
+	createdAt  time.Time
+	finishedAt time.Time
+}
+
+func (j *importJob) snapshot() *domain.ImportJob {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	job := &domain.ImportJob{
+		ID:            j.id,
+		Key:           j.key,
+		Status:        j.status,
+		RowsRead:      j.progress.rowsRead.Load(),
+		Inserted:      j.progress.inserted.Load(),
+		Rejected:      j.progress.rejected.Load(),
+		Duplicates:    j.progress.duplicates.Load(),
+		FailedBatches: j.progress.failedBatches.Load(),
+		Checkpoint:    j.progress.lastCheckpoint(),
+		CreatedAt:     j.createdAt,
+		UpdatedAt:     time.Now().UTC(),
+	}
+	if j.err != nil {
+		job.Error = j.err.Error()
+	}
+	if !j.finishedAt.IsZero() {
+		finishedAt := j.finishedAt
+		job.FinishedAt = &finishedAt
+		job.UpdatedAt = finishedAt
+		job.Report = j.progress.report()
+	}
+	return job
+}
+
+func (j *importJob) finish(err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		j.status = domain.ImportCancelled
+	case err != nil:
+		j.status = domain.ImportFailed
+		j.err = err
+	default:
+		j.status = domain.ImportCompleted
+	}
+	j.finishedAt = time.Now().UTC()
+}
+
+// importJobs is the in-memory registry of background imports.
+type importJobs struct {
+	mu   sync.Mutex
+	jobs map[string]*importJob
+
+	// starting serializes the start of imports, so two uploads with the same
+	// key cannot both start a job
+	starting sync.Mutex
+}
+
+func newImportJobs() *importJobs {
+	return &importJobs{jobs: map[string]*importJob{}}
+}
+
+func (r *importJobs) add(job *importJob) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, j := range r.jobs {
+		j.mu.Lock()
+		expired := !j.finishedAt.IsZero() && time.Since(j.finishedAt) > finishedJobRetention
+		j.mu.Unlock()
+		if expired {
+			delete(r.jobs, id)
+		}
+	}
+	r.jobs[job.id] = job
+}
+
+func (r *importJobs) get(id string) (*importJob, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job, ok := r.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("import job %s: %w", id, domain.ErrNotFound)
+	}
+	return job, nil
+}
+
+func (r *importJobs) byKey(key string) (*importJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, job := range r.jobs {
+		if job.key == key {
+			return job, true
+		}
+	}
+	return nil, false
+}
+
+// StartImport runs the import of reader in the background and returns the
+// job to poll. The job owns reader and closes it once the import has ended.
+// Invalid options and headers are reported before the job is started.
+//
+// An import whose key matches an earlier job returns that job when it is
+// running or completed, and otherwise resumes it from its checkpoint. Rows
+// without a timestamp are stamped with the creation time of the job, so a
+// resumed import stamps them as the first run did and rows that run already
+// wrote are recognized as duplicates.
+func (s *driverLocationService) StartImport(ctx context.Context, reader io.ReadCloser, opts domain.ImportOptions) (*domain.ImportJob, error) {
+	s.imports.starting.Lock()
+	defer s.imports.starting.Unlock()
+
+	prev, err := s.previousImport(ctx, opts.Key)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	if prev != nil && !resumable(prev) {
+		reader.Close()
+		return prev, nil
+	}
+
+	// BSON dates have millisecond precision, so a job read back from the job
+	// store has the same creation time as the one that was saved
+	createdAt := time.Now().UTC().Truncate(time.Millisecond)
+	if prev != nil {
+		createdAt = prev.CreatedAt.UTC()
+	}
+	locations, err := newLocationReader(reader, opts, createdAt)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	// the import outlives the request that started it
+	jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	job := &importJob{
+		key:       opts.Key,
+		status:    domain.ImportRunning,
+		cancel:    cancel,
+		createdAt: createdAt,
+	}
+	if prev != nil {
+		job.id = prev.ID
+		job.progress.resumeFrom(prev.Checkpoint)
+	} else if job.id, err = newJobID(); err != nil {
+		cancel()
+		reader.Close()
+		return nil, err
+	}
+
+	job.progress.jobID = job.id
+
+	if err := s.saveJob(ctx, job); err != nil {
+		cancel()
+		reader.Close()
+		return nil, err
+	}
+	s.imports.add(job)
+
+	go func() {
+		defer cancel()
+		defer reader.Close()
+
+		stop := s.checkpointJob(jobCtx, job)
+		err := s.runImport(jobCtx, locations, &job.progress, 0)
+		stop()
+		if err != nil && !errors.Is(err, context.Canceled) {
+			log.Printf("import job %s failed: %v", job.id, err)
+		}
+		job.finish(err)
+
+		if err := s.saveJob(context.WithoutCancel(jobCtx), job); err != nil {
+			log.Printf("import job %s: save failed: %v", job.id, err)
+		}
+	}()
+
+	return job.snapshot(), nil
+}
+
+// previousImport returns the latest job imported with key, or nil.
+func (s *driverLocationService) previousImport(ctx context.Context, key string) (*domain.ImportJob, error) {
+	if key == "" {
+		return nil, nil
+	}
+	if job, ok := s.imports.byKey(key); ok {
+		return job.snapshot(), nil
+	}
+	if s.jobStore == nil {
+		return nil, nil
+	}
+	job, err := s.jobStore.FindByKey(ctx, key)
+	if errors.Is(err, domain.ErrNotFound) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// resumable reports whether an earlier job should be resumed rather than
+// returned. A running job that has not been checkpointed for a while was
+// left behind by a process that died.
+func resumable(job *domain.ImportJob) bool {
+	switch job.Status {
+	case domain.ImportCompleted:
+		return false
+	case domain.ImportRunning:
+		return time.Since(job.UpdatedAt) > staleJobAfter
+	default:
+		return true
+	}
+}
+
+// checkpointJob saves the job periodically until the returned func is called.
+func (s *driverLocationService) checkpointJob(ctx context.Context, job *importJob) (stop func()) {
+	if s.jobStore == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(checkpointInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := s.saveJob(context.WithoutCancel(ctx), job); err != nil {
+					log.Printf("import job %s: checkpoint failed: %v", job.id, err)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func (s *driverLocationService) saveJob(ctx context.Context, job *importJob) error {
+	if s.jobStore == nil {
+		return nil
+	}
+	return s.jobStore.Save(ctx, job.snapshot())
+}
+
+// ImportJob returns a job of this process, or a job of the job store.
+func (s *driverLocationService) ImportJob(ctx context.Context, id string) (*domain.ImportJob, error) {
+	job, err := s.imports.get(id)
+	if err == nil {
+		return job.snapshot(), nil
+	}
+	if s.jobStore == nil {
+		return nil, err
+	}
+	return s.jobStore.Find(ctx, id)
+}
+
+// CancelImport stops a running import. Batches that were already written are
+// kept. Cancelling a finished job, or a job running in another process, has
+// no effect.
+func (s *driverLocationService) CancelImport(ctx context.Context, id string) (*domain.ImportJob, error) {
+	job, err := s.imports.get(id)
+	if err != nil {
+		return s.ImportJob(ctx, id)
+	}
+	job.cancel()
+	return job.snapshot(), nil
+}
+
+// ImportReport returns the accepted and rejected rows of an import. The report
+// of a running import only covers the rows processed so far.
+func (s *driverLocationService) ImportReport(ctx context.Context, id string) (*domain.ImportReport, error) {
+	job, err := s.imports.get(id)
+	if err == nil {
+		return job.progress.report(), nil
+	}
+	stored, err := s.ImportJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Report == nil {
+		return nil, fmt.Errorf("report of import job %s: %w", id, domain.ErrNotFound)
+	}
+	return stored.Report, nil
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
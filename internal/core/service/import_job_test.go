@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/envercigal/golang/internal/core/domain"
+	circuitbreaker "github.com/envercigal/golang/pkg"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryJobStore implements port.ImportJobStore
+type memoryJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]domain.ImportJob
+}
+
+func newMemoryJobStore(jobs ...domain.ImportJob) *memoryJobStore {
+	s := &memoryJobStore{jobs: map[string]domain.ImportJob{}}
+	for _, job := range jobs {
+		s.jobs[job.ID] = job
+	}
+	return s
+}
+
+func (s *memoryJobStore) Save(ctx context.Context, job *domain.ImportJob) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = *job
+	return nil
+}
+
+func (s *memoryJobStore) Find(ctx context.Context, id string) (*domain.ImportJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, domain.ErrNotFound
+	}
+	return &job, nil
+}
+
+func (s *memoryJobStore) FindByKey(ctx context.Context, key string) (*domain.ImportJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		if job.Key == key {
+			return &job, nil
+		}
+	}
+	return nil, domain.ErrNotFound
+}
+
+func driverRows(n int) io.ReadCloser {
+	var b strings.Builder
+	b.WriteString("driver_id,lat,lon\n")
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, "%d,41,29\n", i)
+	}
+	return io.NopCloser(strings.NewReader(b.String()))
+}
+
+// recordingRepo stores the driver ids of every written batch.
+func recordingRepo() (*mockRepo, func() []int) {
+	var mu sync.Mutex
+	var ids []int
+	repo := &mockRepo{
+		bulkCreateFn: func(ctx context.Context, dls []*domain.DriverLocation) (int, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, dl := range dls {
+				ids = append(ids, dl.DriverID)
+			}
+			return 0, nil
+		},
+	}
+	return repo, func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), ids...)
+	}
+}
+
+func TestStartImport_SameKeyReturnsCompletedJob(t *testing.T) {
+	repo, written := recordingRepo()
+	store := newMemoryJobStore()
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1), WithImportJobStore(store))
+
+	opts := domain.ImportOptions{Key: "upload-1"}
+	first, err := svc.StartImport(context.Background(), driverRows(3), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.ImportCompleted, waitForJob(t, svc, first.ID).Status)
+
+	second, err := svc.StartImport(context.Background(), driverRows(3), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, second.ID)
+	assert.Equal(t, domain.ImportCompleted, second.Status)
+	assert.Len(t, written(), 3)
+
+	stored, err := store.Find(context.Background(), first.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.ImportCompleted, stored.Status)
+	assert.Equal(t, int64(3), stored.Checkpoint)
+	assert.NotNil(t, stored.Report)
+}
+
+func TestStartImport_ResumesFromCheckpoint(t *testing.T) {
+	for name, prev := range map[string]domain.ImportJob{
+		"failed": {Status: domain.ImportFailed},
+		"stale":  {Status: domain.ImportRunning, UpdatedAt: time.Now().Add(-2 * staleJobAfter)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			prev.ID, prev.Key, prev.Checkpoint = "job-1", "upload-1", 4
+			repo, written := recordingRepo()
+			svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1), WithImportJobStore(newMemoryJobStore(prev)))
+
+			job, err := svc.StartImport(context.Background(), driverRows(6), domain.ImportOptions{Key: "upload-1"})
+			assert.NoError(t, err)
+			assert.Equal(t, "job-1", job.ID)
+
+			job = waitForJob(t, svc, job.ID)
+			assert.Equal(t, domain.ImportCompleted, job.Status)
+			assert.Equal(t, int64(2), job.RowsRead)
+			assert.Equal(t, int64(6), job.Checkpoint)
+			assert.ElementsMatch(t, []int{5, 6}, written())
+		})
+	}
+}
+
+func TestStartImport_ResumeKeepsTimestampsOfFirstRun(t *testing.T) {
+	var mu sync.Mutex
+	stamps := map[int]time.Time{}
+	repo := &mockRepo{
+		bulkCreateFn: func(ctx context.Context, dls []*domain.DriverLocation) (int, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			for _, dl := range dls {
+				stamps[dl.DriverID] = dl.Updated
+			}
+			return 0, nil
+		},
+	}
+
+	// the rows have no timestamp column, so a new job stamps them with its
+	// creation time
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1))
+	job, err := svc.StartImport(context.Background(), driverRows(2), domain.ImportOptions{Key: "upload-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, domain.ImportCompleted, waitForJob(t, svc, job.ID).Status)
+	mu.Lock()
+	assert.True(t, job.CreatedAt.Equal(stamps[1]), "stamped %s, created %s", stamps[1], job.CreatedAt)
+	mu.Unlock()
+
+	// a job that failed an hour ago is resumed with the same stamps, so the
+	// rows it wrote past its checkpoint are recognized as duplicates
+	createdAt := time.Now().UTC().Add(-time.Hour).Truncate(time.Millisecond)
+	failed := domain.ImportJob{ID: "job-1", Key: "upload-2", Status: domain.ImportFailed, Checkpoint: 2, CreatedAt: createdAt}
+	svc = NewDriverLocationService(repo, circuitbreaker.New(5, 1), WithImportJobStore(newMemoryJobStore(failed)))
+	job, err = svc.StartImport(context.Background(), driverRows(4), domain.ImportOptions{Key: "upload-2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "job-1", job.ID)
+	assert.Equal(t, domain.ImportCompleted, waitForJob(t, svc, job.ID).Status)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, id := range []int{3, 4} {
+		assert.True(t, createdAt.Equal(stamps[id]), "driver %d stamped %s, not %s", id, stamps[id], createdAt)
+	}
+}
+
+func TestStartImport_DoesNotResumeRunningJob(t *testing.T) {
+	running := domain.ImportJob{ID: "job-1", Key: "upload-1", Status: domain.ImportRunning, UpdatedAt: time.Now()}
+	repo, written := recordingRepo()
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1), WithImportJobStore(newMemoryJobStore(running)))
+
+	job, err := svc.StartImport(context.Background(), driverRows(2), domain.ImportOptions{Key: "upload-1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "job-1", job.ID)
+	assert.Equal(t, domain.ImportRunning, job.Status)
+	assert.Empty(t, written())
+}
+
+func TestImportProgress_CheckpointWaitsForEarlierBatches(t *testing.T) {
+	var p importProgress
+	p.commit(&importBatch{seq: 1, end: 20})
+	assert.Equal(t, int64(0), p.lastCheckpoint())
+
+	p.commit(&importBatch{seq: 0, end: 10})
+	assert.Equal(t, int64(20), p.lastCheckpoint())
+
+	// batch 2 failed, so batch 3 cannot move the checkpoint
+	p.commit(&importBatch{seq: 3, end: 40})
+	assert.Equal(t, int64(20), p.lastCheckpoint())
+}
@@ -0,0 +1,144 @@
+package service
+
+import (
+	"github.com/envercigal/golang/internal/core/domain"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// maxReportedErrors bounds the rejected rows kept in memory per import. Rows
+// beyond it are still counted.
+const maxReportedErrors = 10000
+
+// importBatch is a batch of parsed locations together with the row numbers
+// they were read from. Batches are numbered in the order they were read; end
+// is the last row read before the batch was handed to the workers.
+// duplicates is the number of its locations the store already held.
+type importBatch struct {
+	seq        int64
+	end        int64
+	rows       []int64
+	locations  []*domain.DriverLocation
+	duplicates int
+}
+
+func (b *importBatch) add(row int64, dl *domain.DriverLocation) {
+	b.rows = append(b.rows, row)
+	b.locations = append(b.locations, dl)
+}
+
+// importProgress is updated concurrently by the producer and the workers of
+// an import.
+type importProgress struct {
+	jobID string // empty for imports that do not run as a job
+
+	rowsRead      atomic.Int64
+	inserted      atomic.Int64
+	rejected      atomic.Int64
+	duplicates    atomic.Int64
+	failedBatches atomic.Int64
+
+	mu        sync.Mutex
+	errors    []domain.RowError
+	truncated bool
+
+	// committed holds the end rows of batches that were written out of
+	// order, keyed by batch number, until every batch before them is done.
+	checkpointMu sync.Mutex
+	checkpoint   int64
+	nextBatch    int64
+	committed    map[int64]int64
+}
+
+// resumeFrom starts the progress at the checkpoint of an earlier run.
+func (p *importProgress) resumeFrom(row int64) {
+	p.checkpointMu.Lock()
+	defer p.checkpointMu.Unlock()
+	p.checkpoint = row
+}
+
+// commit records a written batch and moves the checkpoint past every batch
+// that has been written without a gap.
+func (p *importProgress) commit(batch *importBatch) {
+	p.inserted.Add(int64(len(batch.locations) - batch.duplicates))
+	p.duplicates.Add(int64(batch.duplicates))
+
+	p.checkpointMu.Lock()
+	defer p.checkpointMu.Unlock()
+	if p.committed == nil {
+		p.committed = map[int64]int64{}
+	}
+	p.committed[batch.seq] = batch.end
+	for {
+		end, ok := p.committed[p.nextBatch]
+		if !ok {
+			return
+		}
+		delete(p.committed, p.nextBatch)
+		p.checkpoint = end
+		p.nextBatch++
+	}
+}
+
+// lastCheckpoint returns the row up to which the import has been committed.
+func (p *importProgress) lastCheckpoint() int64 {
+	p.checkpointMu.Lock()
+	defer p.checkpointMu.Unlock()
+	return p.checkpoint
+}
+
+func (p *importProgress) reject(row int64, reason string) {
+	p.rejected.Add(1)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.errors) >= maxReportedErrors {
+		p.truncated = true
+		return
+	}
+	p.errors = append(p.errors, domain.RowError{Row: row, Reason: reason})
+}
+
+func (p *importProgress) rejectBatch(batch *importBatch, err error) {
+	p.failedBatches.Add(1)
+	reason := "batch insert failed: " + err.Error()
+	for _, row := range batch.rows {
+		p.reject(row, reason)
+	}
+}
+
+// ack returns the running totals and the rows rejected after the first
+// reported ones.
+func (p *importProgress) ack(reported int) (*domain.StreamAck, int) {
+	ack := &domain.StreamAck{
+		Read:       p.rowsRead.Load(),
+		Accepted:   p.inserted.Load(),
+		Rejected:   p.rejected.Load(),
+		Duplicates: p.duplicates.Load(),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if reported < len(p.errors) {
+		ack.Errors = append([]domain.RowError(nil), p.errors[reported:]...)
+	}
+	return ack, len(p.errors)
+}
+
+func (p *importProgress) report() *domain.ImportReport {
+	p.mu.Lock()
+	errors := make([]domain.RowError, len(p.errors))
+	copy(errors, p.errors)
+	truncated := p.truncated
+	p.mu.Unlock()
+
+	sort.Slice(errors, func(i, j int) bool { return errors[i].Row < errors[j].Row })
+	return &domain.ImportReport{
+		Accepted:   p.inserted.Load(),
+		Rejected:   p.rejected.Load(),
+		Duplicates: p.duplicates.Load(),
+		Errors:     errors,
+		Truncated:  truncated,
+	}
+}
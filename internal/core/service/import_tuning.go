@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	defaultImportWorkers   = 16
+	defaultImportBatchSize = 1000
+	defaultWriteLatency    = time.Second
+	minImportBatchSize     = 50
+
+	defaultWriteAttempts = 5
+	defaultRetryDelay    = 200 * time.Millisecond
+	maxRetryDelay        = 10 * time.Second
+
+	defaultStreamFlush = time.Second
+
+	// latencySmoothing is the weight of a new sample in the latency average
+	latencySmoothing = 0.2
+)
+
+// WithImportWorkers sets the maximum number of batches an import writes
+// concurrently.
+func WithImportWorkers(n int) Option {
+	return func(s *driverLocationService) {
+		if n > 0 {
+			s.maxWorkers = n
+		}
+	}
+}
+
+// WithImportBatchSize sets the number of rows per written batch. It is also
+// the largest batch the import grows back to after it had to shrink them.
+func WithImportBatchSize(n int) Option {
+	return func(s *driverLocationService) {
+		if n > 0 {
+			s.batchSize = n
+		}
+	}
+}
+
+// WithImportWriteLatency sets the batch write latency imports aim for. Slower
+// writes reduce the concurrency and then the batch size of an import, faster
+// ones raise them back up to the configured limits.
+func WithImportWriteLatency(d time.Duration) Option {
+	return func(s *driverLocationService) {
+		if d > 0 {
+			s.writeLatency = d
+		}
+	}
+}
+
+// WithImportRetry sets how often a batch write that failed with a transient
+// error is attempted, and the delay before the first retry. The delay doubles
+// with every attempt.
+func WithImportRetry(attempts int, delay time.Duration) Option {
+	return func(s *driverLocationService) {
+		if attempts > 0 {
+			s.writeAttempts = attempts
+		}
+		if delay > 0 {
+			s.retryDelay = delay
+		}
+	}
+}
+
+// WithStreamFlushInterval sets how long a row of a location stream may wait
+// for its batch to fill before the batch is written anyway. It is also the
+// interval of the stream's acknowledgements.
+func WithStreamFlushInterval(d time.Duration) Option {
+	return func(s *driverLocationService) {
+		if d > 0 {
+			s.streamFlush = d
+		}
+	}
+}
+
+// writeTuner adapts the concurrency and batch size of an import to the
+// observed write latency. Concurrency is halved when writes are slower than
+// the target and raised by one when they are well below it; the batch size
+// only changes once the concurrency is at its bound.
+type writeTuner struct {
+	target       time.Duration
+	maxWorkers   int
+	maxBatchSize int
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	limit     int
+	inFlight  int
+	batchSize int
+	latency   time.Duration
+	samples   int
+}
+
+func newWriteTuner(maxWorkers, batchSize int, target time.Duration) *writeTuner {
+	t := &writeTuner{
+		target:       target,
+		maxWorkers:   maxWorkers,
+		maxBatchSize: batchSize,
+		limit:        maxWorkers,
+		batchSize:    batchSize,
+	}
+	t.cond = sync.NewCond(&t.mu)
+	return t
+}
+
+// acquire blocks until the import may start another write.
+func (t *writeTuner) acquire(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		t.cond.Broadcast()
+	})
+	defer stop()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for t.inFlight >= t.limit {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		t.cond.Wait()
+	}
+	t.inFlight++
+	return nil
+}
+
+// release ends a write that took d. Failed writes count as slow ones.
+func (t *writeTuner) release(d time.Duration, failed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.cond.Broadcast()
+
+	t.inFlight--
+	if failed {
+		d = max(d, 2*t.target)
+	}
+	if t.latency == 0 {
+		t.latency = d
+	} else {
+		t.latency = time.Duration(latencySmoothing*float64(d) + (1-latencySmoothing)*float64(t.latency))
+	}
+
+	// give every setting a round of writes before judging it
+	t.samples++
+	if t.samples < t.limit {
+		return
+	}
+	t.samples = 0
+
+	switch {
+	case t.latency > t.target && t.limit > 1:
+		t.limit = max(1, t.limit/2)
+	case t.latency > t.target:
+		t.batchSize = max(minImportBatchSize, t.batchSize/2)
+	case t.latency < t.target/2 && t.batchSize < t.maxBatchSize:
+		t.batchSize = min(t.maxBatchSize, t.batchSize*2)
+	case t.latency < t.target/2 && t.limit < t.maxWorkers:
+		t.limit++
+	}
+}
+
+// currentBatchSize is the number of rows the next batch should hold.
+func (t *writeTuner) currentBatchSize() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.batchSize
+}
+
+// retryDelay returns the randomized exponential backoff before the given
+// retry, starting at 1.
+func retryDelay(base time.Duration, retry int) time.Duration {
+	d := base << (retry - 1)
+	if d <= 0 || d > maxRetryDelay {
+		d = maxRetryDelay
+	}
+	// jitter keeps the workers of an import from retrying in lockstep
+	return d/2 + rand.N(d/2+1)
+}
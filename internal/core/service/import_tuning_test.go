@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/envercigal/golang/internal/core/domain"
+	circuitbreaker "github.com/envercigal/golang/pkg"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryDeadLetters struct {
+	mu      sync.Mutex
+	letters []*domain.DeadLetter
+}
+
+func (m *memoryDeadLetters) Add(ctx context.Context, letter *domain.DeadLetter) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.letters = append(m.letters, letter)
+	return nil
+}
+
+func TestBulkCreate_RetriesTransientErrors(t *testing.T) {
+	var calls atomic.Int32
+	repo := &mockRepo{
+		bulkCreateFn: func(ctx context.Context, dls []*domain.DriverLocation) (int, error) {
+			if calls.Add(1) < 3 {
+				return 0, fmt.Errorf("%w: primary stepped down", domain.ErrTransient)
+			}
+			return 0, nil
+		},
+	}
+	letters := &memoryDeadLetters{}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1),
+		WithImportRetry(3, time.Millisecond), WithDeadLetterStore(letters))
+
+	report, err := svc.BulkCreate(context.Background(), strings.NewReader("driver_id,lat,lon\n1,41,29\n"), domain.ImportOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), report.Accepted)
+	assert.Equal(t, int32(3), calls.Load())
+	assert.Empty(t, letters.letters)
+}
+
+func TestBulkCreate_DeadLettersFailedBatches(t *testing.T) {
+	for name, tc := range map[string]struct {
+		err      error
+		attempts int
+		reason   string
+	}{
+		"transient": {
+			err:      fmt.Errorf("%w: connection reset", domain.ErrTransient),
+			attempts: 3,
+			reason:   "batch insert failed: after 3 attempts: transient error: connection reset",
+		},
+		"permanent": {
+			err:      errors.New("document too large"),
+			attempts: 1,
+			reason:   "batch insert failed: document too large",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var calls atomic.Int32
+			repo := &mockRepo{
+				bulkCreateFn: func(ctx context.Context, dls []*domain.DriverLocation) (int, error) {
+					calls.Add(1)
+					return 0, tc.err
+				},
+			}
+			letters := &memoryDeadLetters{}
+			svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1),
+				WithImportRetry(3, time.Millisecond), WithDeadLetterStore(letters))
+
+			report, err := svc.BulkCreate(context.Background(), strings.NewReader("driver_id,lat,lon\n1,41,29\n2,41,29\n"), domain.ImportOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, int64(2), report.Rejected)
+			assert.Equal(t, tc.reason, report.Errors[0].Reason)
+			assert.Equal(t, int32(tc.attempts), calls.Load())
+
+			assert.Len(t, letters.letters, 1)
+			assert.Equal(t, []int64{1, 2}, letters.letters[0].Rows)
+			assert.Len(t, letters.letters[0].Locations, 2)
+			assert.Equal(t, tc.attempts, letters.letters[0].Attempts)
+		})
+	}
+}
+
+func TestBulkCreate_UsesConfiguredBatchSize(t *testing.T) {
+	var mu sync.Mutex
+	var sizes []int
+	repo := &mockRepo{
+		bulkCreateFn: func(ctx context.Context, dls []*domain.DriverLocation) (int, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			sizes = append(sizes, len(dls))
+			return 0, nil
+		},
+	}
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1), WithImportBatchSize(2), WithImportWorkers(1))
+
+	report, err := svc.BulkCreate(context.Background(), strings.NewReader("driver_id,lat,lon\n1,41,29\n2,41,29\n3,41,29\n4,41,29\n5,41,29\n"), domain.ImportOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), report.Accepted)
+	assert.Equal(t, []int{2, 2, 1}, sizes)
+}
+
+func TestWriteTuner_AdaptsToLatency(t *testing.T) {
+	tuner := newWriteTuner(4, 1000, 100*time.Millisecond)
+	write := func(d time.Duration) {
+		assert.NoError(t, tuner.acquire(context.Background()))
+		tuner.release(d, false)
+	}
+
+	// slow writes reduce the concurrency before the batch size
+	for i := 0; i < 4; i++ {
+		write(time.Second)
+	}
+	assert.Equal(t, 2, tuner.limit)
+	assert.Equal(t, 1000, tuner.currentBatchSize())
+	write(time.Second)
+	write(time.Second)
+	assert.Equal(t, 1, tuner.limit)
+	write(time.Second)
+	assert.Equal(t, 500, tuner.currentBatchSize())
+
+	// fast writes restore the batch size, then the concurrency
+	for i := 0; i < 40; i++ {
+		write(time.Millisecond)
+	}
+	assert.Equal(t, 1000, tuner.currentBatchSize())
+	assert.Equal(t, 4, tuner.limit)
+}
+
+func TestWriteTuner_AcquireHonoursLimit(t *testing.T) {
+	tuner := newWriteTuner(1, 1000, time.Second)
+	assert.NoError(t, tuner.acquire(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, tuner.acquire(ctx), context.DeadlineExceeded)
+
+	tuner.release(time.Millisecond, false)
+	assert.NoError(t, tuner.acquire(context.Background()))
+}
+
+func TestRetryDelay(t *testing.T) {
+	for retry := 1; retry <= 10; retry++ {
+		d := retryDelay(200*time.Millisecond, retry)
+		want := min(200*time.Millisecond<<(retry-1), maxRetryDelay)
+		assert.GreaterOrEqual(t, d, want/2)
+		assert.LessOrEqual(t, d, want)
+	}
+	assert.Equal(t, maxRetryDelay, max(retryDelay(time.Second, 70), maxRetryDelay))
+}
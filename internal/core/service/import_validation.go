@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/envercigal/golang/internal/core/domain"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// maxReportedDuplicates bounds the duplicate driver ids listed in a summary.
+const maxReportedDuplicates = 1000
+
+// ValidateImport runs the parsing and validation of an import without
+// writing anything, and summarizes the rows that would be imported.
+func (s *driverLocationService) ValidateImport(ctx context.Context, reader io.Reader, opts domain.ImportOptions) (*domain.ImportSummary, error) {
+	locations, err := newLocationReader(reader, opts, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	var progress importProgress
+	box := domain.BoundingBox{MinLon: math.Inf(1), MinLat: math.Inf(1), MaxLon: math.Inf(-1), MaxLat: math.Inf(-1)}
+	var from, to time.Time
+	rowsPerDriver := map[int]int{}
+
+	var row int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		dl, err := locations.Next()
+		if err == io.EOF {
+			break
+		}
+		row++
+		if err != nil {
+			var rowErr *rowError
+			if !errors.As(err, &rowErr) {
+				return nil, err
+			}
+			progress.reject(row, rowErr.Error())
+			continue
+		}
+
+		lon, lat := dl.Location.Coordinates[0], dl.Location.Coordinates[1]
+		box.MinLon, box.MaxLon = min(box.MinLon, lon), max(box.MaxLon, lon)
+		box.MinLat, box.MaxLat = min(box.MinLat, lat), max(box.MaxLat, lat)
+		if from.IsZero() || dl.Updated.Before(from) {
+			from = dl.Updated
+		}
+		if dl.Updated.After(to) {
+			to = dl.Updated
+		}
+		rowsPerDriver[dl.DriverID]++
+	}
+
+	report := progress.report()
+	summary := &domain.ImportSummary{
+		Rows:               row,
+		Valid:              row - report.Rejected,
+		Rejected:           report.Rejected,
+		Drivers:            int64(len(rowsPerDriver)),
+		DuplicateDriverIDs: []int{},
+		Errors:             report.Errors,
+		Truncated:          report.Truncated,
+	}
+	if summary.Valid > 0 {
+		summary.BoundingBox = &box
+		summary.From, summary.To = &from, &to
+	}
+	for id, n := range rowsPerDriver {
+		if n > 1 {
+			summary.DuplicateDriverIDs = append(summary.DuplicateDriverIDs, id)
+		}
+	}
+	sort.Ints(summary.DuplicateDriverIDs)
+	if len(summary.DuplicateDriverIDs) > maxReportedDuplicates {
+		summary.DuplicateDriverIDs = summary.DuplicateDriverIDs[:maxReportedDuplicates]
+		summary.DuplicatesTruncated = true
+	}
+	return summary, nil
+}
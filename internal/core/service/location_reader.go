@@ -0,0 +1,290 @@
+package service
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/envercigal/golang/internal/core/domain"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// locationReader yields the locations of an import one row at a time.
+//
+// Next returns io.EOF at the end of the input. A *rowError rejects only the
+// current row and reading continues; any other error aborts the import.
+type locationReader interface {
+	Next() (*domain.DriverLocation, error)
+}
+
+type rowError struct {
+	err error
+}
+
+func (e *rowError) Error() string { return e.err.Error() }
+
+func (e *rowError) Unwrap() error { return e.err }
+
+func rejectRow(format string, args ...interface{}) error {
+	return &rowError{err: fmt.Errorf(format, args...)}
+}
+
+func validateImportOptions(opts domain.ImportOptions) error {
+	switch opts.Format {
+	case domain.FormatCSV, "":
+	case domain.FormatGeoJSON, domain.FormatNDJSON:
+		if opts.Delimiter != 0 || len(opts.Columns) > 0 {
+			return fmt.Errorf("%w: delimiter and columns only apply to csv imports", domain.ErrInvalidArgument)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported import format %q", domain.ErrInvalidArgument, opts.Format)
+	}
+
+	if d := opts.Delimiter; d != 0 && (d == '"' || d == '\r' || d == '\n' || !utf8.ValidRune(d) || d == utf8.RuneError) {
+		return fmt.Errorf("%w: invalid delimiter %q", domain.ErrInvalidArgument, d)
+	}
+	for column, name := range opts.Columns {
+		if !slices.Contains(csvColumns, column) {
+			return fmt.Errorf("%w: unknown column %q", domain.ErrInvalidArgument, column)
+		}
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("%w: empty header for column %q", domain.ErrInvalidArgument, column)
+		}
+	}
+	return nil
+}
+
+// newLocationReader returns the parser of the import format. Rows without a
+// timestamp are stamped with now, see stampingReader.
+func newLocationReader(r io.Reader, opts domain.ImportOptions, now time.Time) (locationReader, error) {
+	if err := validateImportOptions(opts); err != nil {
+		return nil, err
+	}
+	var locations locationReader
+	var err error
+	switch opts.Format {
+	case domain.FormatGeoJSON:
+		locations, err = newGeoJSONLocationReader(r)
+	case domain.FormatNDJSON:
+		locations = newNDJSONLocationReader(r)
+	default:
+		locations, err = newCSVLocationReader(r, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newStampingReader(locations, now), nil
+}
+
+// stampingReader stamps the rows read without a timestamp with now, or with
+// the time they are read when now is zero. Pings are unique per driver and
+// updated_at, so later untimed rows of a driver are moved a millisecond past
+// the previous one instead of colliding with it. The stamps only depend on
+// the order of the rows, so a resumed import stamps its rows the same way.
+type stampingReader struct {
+	locationReader
+	now  time.Time
+	last map[int]time.Time // latest stamp given to each driver
+}
+
+func newStampingReader(r locationReader, now time.Time) *stampingReader {
+	return &stampingReader{locationReader: r, now: now.Truncate(time.Millisecond), last: map[int]time.Time{}}
+}
+
+func (s *stampingReader) Next() (*domain.DriverLocation, error) {
+	dl, err := s.locationReader.Next()
+	if err != nil || !dl.Updated.IsZero() {
+		return dl, err
+	}
+
+	updated := s.now
+	if updated.IsZero() {
+		updated = time.Now().UTC().Truncate(time.Millisecond)
+	}
+	if last, ok := s.last[dl.DriverID]; ok && !updated.After(last) {
+		updated = last.Add(time.Millisecond)
+	}
+	s.last[dl.DriverID] = updated
+	dl.Updated = updated
+	return dl, nil
+}
+
+// Columns of a CSV import. They are matched against the header case
+// insensitively, unless ImportOptions.Columns names a different header.
+const (
+	columnDriverID    = "driver_id"
+	columnLat         = "lat"
+	columnLon         = "lon"
+	columnTimestamp   = "timestamp"
+	columnStatus      = "status"
+	columnVehicleType = "vehicle_type"
+)
+
+var (
+	csvColumns      = []string{columnDriverID, columnLat, columnLon, columnTimestamp, columnStatus, columnVehicleType}
+	requiredColumns = []string{columnDriverID, columnLat, columnLon}
+)
+
+type csvLocationReader struct {
+	r *csv.Reader
+	// index holds the position of every column found in the header
+	index map[string]int
+}
+
+func newCSVLocationReader(r io.Reader, opts domain.ImportOptions) (*csvLocationReader, error) {
+	csvFile := csv.NewReader(r)
+	if opts.Delimiter != 0 {
+		csvFile.Comma = opts.Delimiter
+	}
+
+	header, err := csvFile.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: missing csv header", domain.ErrInvalidArgument)
+	}
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, fmt.Errorf("%w: invalid csv header: %v", domain.ErrInvalidArgument, err)
+		}
+		return nil, err
+	}
+
+	index, err := columnIndex(header, opts.Columns)
+	if err != nil {
+		return nil, err
+	}
+	return &csvLocationReader{r: csvFile, index: index}, nil
+}
+
+// columnIndex maps the columns to their position in the header and fails
+// when a required column is missing.
+func columnIndex(header []string, overrides map[string]string) (map[string]int, error) {
+	positions := make(map[string]int, len(header))
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := positions[name]; ok {
+			return nil, fmt.Errorf("%w: duplicate csv header %q", domain.ErrInvalidArgument, name)
+		}
+		positions[name] = i
+	}
+
+	index := make(map[string]int, len(csvColumns))
+	var missing []string
+	for _, column := range csvColumns {
+		name := column
+		if override, ok := overrides[column]; ok {
+			name = strings.ToLower(strings.TrimSpace(override))
+		}
+		if i, ok := positions[name]; ok {
+			index[column] = i
+		} else if slices.Contains(requiredColumns, column) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: missing required csv columns: %s", domain.ErrInvalidArgument, strings.Join(missing, ", "))
+	}
+	return index, nil
+}
+
+func (c *csvLocationReader) Next() (*domain.DriverLocation, error) {
+	record, err := c.r.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, &rowError{err: err}
+		}
+		return nil, err
+	}
+
+	dl, err := c.toDriverLocation(record)
+	if err != nil {
+		return nil, &rowError{err: err}
+	}
+	return dl, nil
+}
+
+// field returns the trimmed value of the column, empty when the header does
+// not have it.
+func (c *csvLocationReader) field(record []string, column string) string {
+	i, ok := c.index[column]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+func (c *csvLocationReader) toDriverLocation(record []string) (*domain.DriverLocation, error) {
+	driverID, err := strconv.Atoi(c.field(record, columnDriverID))
+	if err != nil {
+		return nil, fmt.Errorf("invalid driver_id %q", c.field(record, columnDriverID))
+	}
+	lat, err := strconv.ParseFloat(c.field(record, columnLat), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lat %q", c.field(record, columnLat))
+	}
+	lon, err := strconv.ParseFloat(c.field(record, columnLon), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lon %q", c.field(record, columnLon))
+	}
+	if err := validateCoords(lat, lon); err != nil {
+		return nil, err
+	}
+
+	status, err := parseStatus(c.field(record, columnStatus))
+	if err != nil {
+		return nil, err
+	}
+
+	var updated time.Time
+	if v := c.field(record, columnTimestamp); v != "" {
+		if updated, err = parseTimestamp(v); err != nil {
+			return nil, err
+		}
+	}
+
+	return &domain.DriverLocation{
+		DriverID: driverID,
+		Location: domain.GeoJSONPoint{
+			Type:        "Point",
+			Coordinates: []float64{lon, lat},
+		},
+		Status:      status,
+		VehicleType: c.field(record, columnVehicleType),
+		Updated:     updated,
+	}, nil
+}
+
+// parseStatus returns the status of an imported row, available when empty.
+func parseStatus(v string) (domain.DriverStatus, error) {
+	if v == "" {
+		return domain.StatusAvailable, nil
+	}
+	status := domain.DriverStatus(v)
+	if !status.Valid() {
+		return "", fmt.Errorf("invalid status %q", v)
+	}
+	return status, nil
+}
+
+// parseTimestamp accepts RFC 3339 timestamps and Unix seconds.
+func parseTimestamp(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), nil
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", v)
+}
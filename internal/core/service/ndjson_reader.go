@@ -0,0 +1,102 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/envercigal/golang/internal/core/domain"
+	"io"
+	"time"
+)
+
+// maxNDJSONLine bounds the memory used by a single line of a stream.
+const maxNDJSONLine = 64 * 1024
+
+// ndjsonLocationReader reads one JSON encoded location per line. A malformed
+// line only rejects that line, so a long running stream is never aborted by
+// a single bad update.
+type ndjsonLocationReader struct {
+	r *bufio.Reader
+}
+
+type ndjsonLocation struct {
+	DriverID    *int                `json:"driver_id"`
+	Location    domain.GeoJSONPoint `json:"location"`
+	Status      string              `json:"status"`
+	VehicleType string              `json:"vehicle_type"`
+	Updated     *time.Time          `json:"updated_at"`
+}
+
+func newNDJSONLocationReader(r io.Reader) *ndjsonLocationReader {
+	return &ndjsonLocationReader{r: bufio.NewReaderSize(r, maxNDJSONLine)}
+}
+
+func (n *ndjsonLocationReader) Next() (*domain.DriverLocation, error) {
+	for {
+		line, err := n.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		return n.parse(line)
+	}
+}
+
+// readLine returns the next line without its terminator. Lines longer than
+// maxNDJSONLine are skipped and rejected.
+func (n *ndjsonLocationReader) readLine() ([]byte, error) {
+	line, err := n.r.ReadSlice('\n')
+	switch {
+	case errors.Is(err, bufio.ErrBufferFull):
+		for errors.Is(err, bufio.ErrBufferFull) {
+			_, err = n.r.ReadSlice('\n')
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		return nil, rejectRow("line exceeds %d bytes", maxNDJSONLine)
+	case err == io.EOF && len(line) > 0:
+		return line, nil
+	case err != nil:
+		return nil, err
+	}
+	return line, nil
+}
+
+func (n *ndjsonLocationReader) parse(line []byte) (*domain.DriverLocation, error) {
+	var l ndjsonLocation
+	if err := json.Unmarshal(line, &l); err != nil {
+		return nil, &rowError{err: fmt.Errorf("malformed line: %w", err)}
+	}
+	if l.DriverID == nil {
+		return nil, rejectRow("missing driver_id")
+	}
+	if l.Location.Type != "Point" || len(l.Location.Coordinates) != 2 {
+		return nil, rejectRow("location must be a Point with longitude and latitude")
+	}
+	lon, lat := l.Location.Coordinates[0], l.Location.Coordinates[1]
+	if err := validateCoords(lat, lon); err != nil {
+		return nil, &rowError{err: err}
+	}
+	status, err := parseStatus(l.Status)
+	if err != nil {
+		return nil, &rowError{err: err}
+	}
+
+	var updated time.Time
+	if l.Updated != nil {
+		updated = l.Updated.UTC()
+	}
+
+	return &domain.DriverLocation{
+		DriverID:    *l.DriverID,
+		Location:    l.Location,
+		Status:      status,
+		VehicleType: l.VehicleType,
+		Updated:     updated,
+	}, nil
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"context"
+	"github.com/envercigal/golang/internal/core/domain"
+	"io"
+	"sync"
+	"time"
+)
+
+// StreamCreate ingests newline delimited JSON location updates as they
+// arrive. A batch is written once it is full or the flush interval after its
+// first line, so a slow stream is persisted while it is still open. Lines
+// without a timestamp are stamped when they are read.
+//
+// ack is called with the running totals every flush interval in which they
+// changed, and a last time once the stream has ended. Calls to ack never
+// overlap.
+func (s *driverLocationService) StreamCreate(ctx context.Context, reader io.Reader, ack func(*domain.StreamAck)) error {
+	// a zero time stamps every line with the time it is read
+	locations := newStampingReader(newNDJSONLocationReader(reader), time.Time{})
+
+	progress := &importProgress{}
+	stop := s.ackProgress(progress, ack)
+	err := s.runImport(ctx, locations, progress, s.streamFlush)
+	reported := stop()
+
+	last, _ := progress.ack(reported)
+	last.Done = true
+	if err != nil {
+		last.Error = err.Error()
+	}
+	ack(last)
+	return err
+}
+
+// ackProgress acknowledges the progress of a stream periodically until the
+// returned func is called, which returns how many rejected rows were
+// acknowledged.
+func (s *driverLocationService) ackProgress(progress *importProgress, ack func(*domain.StreamAck)) (stop func() int) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	var reported int
+	var last domain.StreamAck
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(s.streamFlush)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				next, n := progress.ack(reported)
+				if next.Read == last.Read && next.Accepted == last.Accepted && next.Rejected == last.Rejected {
+					continue
+				}
+				ack(next)
+				last, reported = *next, n
+			}
+		}
+	}()
+
+	return func() int {
+		close(done)
+		wg.Wait()
+		return reported
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/envercigal/golang/internal/core/domain"
+	circuitbreaker "github.com/envercigal/golang/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamCreate_PersistsWhileTheStreamIsOpen(t *testing.T) {
+	repo, written := recordingRepo()
+	svc := NewDriverLocationService(repo, circuitbreaker.New(5, 1), WithStreamFlushInterval(20*time.Millisecond))
+
+	body, w := io.Pipe()
+	acks := make(chan domain.StreamAck, 100)
+	done := make(chan error)
+	go func() {
+		done <- svc.StreamCreate(context.Background(), body, func(ack *domain.StreamAck) {
+			acks <- *ack
+		})
+	}()
+
+	for i := 1; i <= 3; i++ {
+		_, err := fmt.Fprintf(w, `{"driver_id":%d,"location":{"type":"Point","coordinates":[29,41]}}`+"\n", i)
+		assert.NoError(t, err)
+	}
+	_, err := io.WriteString(w, "not json\n")
+	assert.NoError(t, err)
+
+	// the lines are written and acknowledged long before a batch is full
+	var rejected []domain.RowError
+	var last domain.StreamAck
+	assert.Eventually(t, func() bool {
+		for {
+			select {
+			case last = <-acks:
+				rejected = append(rejected, last.Errors...)
+				if last.Accepted == 3 {
+					return true
+				}
+			default:
+				return false
+			}
+		}
+	}, time.Second, 5*time.Millisecond)
+	assert.ElementsMatch(t, []int{1, 2, 3}, written())
+	assert.False(t, last.Done)
+
+	assert.NoError(t, w.Close())
+	assert.NoError(t, <-done)
+
+	for !last.Done {
+		last = <-acks
+		rejected = append(rejected, last.Errors...)
+	}
+	assert.Equal(t, int64(4), last.Read)
+	assert.Equal(t, int64(3), last.Accepted)
+	assert.Equal(t, int64(1), last.Rejected)
+	assert.Len(t, rejected, 1, "every rejected line is acknowledged once")
+	assert.Equal(t, int64(4), rejected[0].Row)
+}
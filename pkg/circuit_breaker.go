@@ -1,6 +1,7 @@
 package circuitbreaker
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"time"
@@ -17,72 +18,313 @@ const (
 	HalfOpen
 )
 
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Counts are the totals of the calls a breaker has seen since it was created.
+type Counts struct {
+	Requests   uint64 `json:"requests"`
+	Successes  uint64 `json:"successes"`
+	Failures   uint64 `json:"failures"`
+	Rejections uint64 `json:"rejections"` // calls refused while open or half-open
+}
+
+// StateChangeFunc is called with the breaker's name when its state changes.
+type StateChangeFunc func(name string, from, to State)
+
+// Breaker trips after maxFailures consecutive failures by default. With a
+// sliding window it trips on the failure rate of the recent calls instead.
 type Breaker struct {
+	name string
+
 	mu           sync.Mutex
 	state        State
 	failureCount int
 	lastFailure  time.Time
 	maxFailures  int
 	resetTimeout time.Duration
+
+	window      window
+	failureRate float64
+	minRequests int
+
+	// half-open probing
+	maxProbes         int
+	requiredSuccesses int
+	probes            int // probes in flight
+	probeSuccesses    int
+
+	// generation changes with every state change
+	generation uint64
+
+	counts        Counts
+	onStateChange []StateChangeFunc
+
+	timeout      time.Duration
+	isSuccessful func(error) bool
 }
 
-func New(maxFailures int, resetTimeout time.Duration) *Breaker {
-	return &Breaker{
-		state:        Closed,
-		maxFailures:  maxFailures,
-		resetTimeout: resetTimeout,
+type Option func(*Breaker)
+
+// WithCountWindow makes the breaker trip when, among the last size calls,
+// the share of failures reaches failureRate. The rate is only evaluated once
+// the window holds at least minRequests calls.
+func WithCountWindow(size int, failureRate float64, minRequests int) Option {
+	return func(b *Breaker) {
+		b.window = newCountWindow(size)
+		b.failureRate = failureRate
+		b.minRequests = minRequests
 	}
 }
 
-func (b *Breaker) Execute(fn func() error) error {
-	if !b.allowRequest() {
-		return ErrOpen
+// WithTimeWindow makes the breaker trip when, among the calls of the last d,
+// the share of failures reaches failureRate. The rate is only evaluated once
+// the window holds at least minRequests calls.
+func WithTimeWindow(d time.Duration, failureRate float64, minRequests int) Option {
+	return func(b *Breaker) {
+		b.window = newTimeWindow(d)
+		b.failureRate = failureRate
+		b.minRequests = minRequests
+	}
+}
+
+// WithHalfOpenProbes lets at most maxProbes calls run at once while the
+// breaker is half-open; further calls fail with ErrHalfOpen. The breaker
+// closes after successes successful probes and reopens on a failed one. By
+// default a single probe decides.
+func WithHalfOpenProbes(maxProbes, successes int) Option {
+	return func(b *Breaker) {
+		b.maxProbes = max(maxProbes, 1)
+		b.requiredSuccesses = max(successes, 1)
+	}
+}
+
+// WithName names the breaker in state change callbacks, snapshots and metrics.
+func WithName(name string) Option {
+	return func(b *Breaker) {
+		b.name = name
 	}
+}
+
+// OnStateChange registers fn to be called on every state change. fn runs
+// while the breaker is locked, so it must not call back into the breaker.
+func OnStateChange(fn StateChangeFunc) Option {
+	return func(b *Breaker) {
+		b.onStateChange = append(b.onStateChange, fn)
+	}
+}
+
+// WithTimeout bounds every call of ExecuteContext to d. A call that runs
+// into the timeout counts as a failure.
+func WithTimeout(d time.Duration) Option {
+	return func(b *Breaker) {
+		b.timeout = d
+	}
+}
+
+// WithIsSuccessful makes the breaker count the errors fn accepts as
+// successful calls, such as a query that found nothing. They are still
+// returned to the caller. By default every error is a failure.
+func WithIsSuccessful(fn func(err error) bool) Option {
+	return func(b *Breaker) {
+		b.isSuccessful = fn
+	}
+}
+
+func New(maxFailures int, resetTimeout time.Duration, opts ...Option) *Breaker {
+	b := &Breaker{
+		state:             Closed,
+		maxFailures:       maxFailures,
+		resetTimeout:      resetTimeout,
+		maxProbes:         1,
+		requiredSuccesses: 1,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
 
-	err := fn()
+// Counts returns the call totals of the breaker.
+func (b *Breaker) Counts() Counts {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.counts
+}
 
+func (b *Breaker) Execute(fn func() error) error {
+	return b.ExecuteContext(context.Background(), func(context.Context) error {
+		return fn()
+	})
+}
+
+// ExecuteContext runs fn with a context that ends with ctx or after the
+// timeout of the breaker, whichever comes first. fn must return once its
+// context is done. Running into the timeout counts as a failure; calls the
+// caller gave up on, because ctx was cancelled or reached its own deadline,
+// count neither as a failure nor as a success.
+func (b *Breaker) ExecuteContext(ctx context.Context, fn func(context.Context) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	generation, err := b.allowRequest()
 	if err != nil {
-		b.recordFailure()
 		return err
 	}
 
-	b.recordSuccess()
-	return nil
+	callCtx := ctx
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+
+	err = fn(callCtx)
+
+	switch {
+	case err == nil:
+		b.recordSuccess(generation)
+		return nil
+	case ctx.Err() != nil:
+		b.recordAbandoned(generation)
+		return err
+	case b.isSuccessful != nil && b.isSuccessful(err):
+		b.recordSuccess(generation)
+		return err
+	default:
+		b.recordFailure(generation)
+		return err
+	}
 }
 
-func (b *Breaker) allowRequest() bool {
+// allowRequest reports whether a call may run. It returns the generation of
+// the state the call was admitted in, so a call that outlives that state
+// does not affect the next one.
+func (b *Breaker) allowRequest() (uint64, error) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
 	switch b.state {
 	case Open:
-		if time.Since(b.lastFailure) > b.resetTimeout {
-			b.state = HalfOpen
-			return true
+		if time.Since(b.lastFailure) <= b.resetTimeout {
+			b.counts.Rejections++
+			return 0, ErrOpen
 		}
-		return false
+		b.setState(HalfOpen)
+		fallthrough
 	case HalfOpen:
-		return true
+		if b.probes >= b.maxProbes {
+			b.counts.Rejections++
+			return 0, ErrHalfOpen
+		}
+		b.probes++
+		b.counts.Requests++
+		return b.generation, nil
+	default:
+		b.counts.Requests++
+		return b.generation, nil
+	}
+}
+
+// setState moves the breaker to state and starts a new generation.
+func (b *Breaker) setState(state State) {
+	from := b.state
+	b.state = state
+	b.generation++
+	b.failureCount = 0
+	b.probes, b.probeSuccesses = 0, 0
+	if b.window != nil {
+		b.window.reset()
+	}
+	for _, fn := range b.onStateChange {
+		fn(b.name, from, state)
+	}
+}
+
+func (b *Breaker) recordFailure(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.counts.Failures++
+	if generation != b.generation {
+		return
+	}
+
+	now := time.Now()
+	b.lastFailure = now
+	switch {
+	case b.state == HalfOpen:
+		// a failed probe reopens the breaker right away
+		b.setState(Open)
+	case b.window == nil:
+		b.failureCount++
+		if b.failureCount >= b.maxFailures {
+			b.setState(Open)
+		}
 	default:
-		return true
+		b.window.record(now, true)
+		requests, failures := b.window.counts(now)
+		if requests >= b.minRequests && float64(failures) >= b.failureRate*float64(requests) {
+			b.setState(Open)
+		}
 	}
 }
 
-func (b *Breaker) recordFailure() {
+func (b *Breaker) recordSuccess(generation uint64) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	b.failureCount++
-	b.lastFailure = time.Now()
-	if b.failureCount >= b.maxFailures {
-		b.state = Open
+	b.counts.Successes++
+	if generation != b.generation {
+		return
+	}
+
+	switch {
+	case b.state == HalfOpen:
+		b.probes--
+		b.probeSuccesses++
+		if b.probeSuccesses >= b.requiredSuccesses {
+			b.setState(Closed)
+		}
+	case b.window == nil:
+		b.failureCount = 0
+	default:
+		b.window.record(time.Now(), false)
 	}
 }
 
-func (b *Breaker) recordSuccess() {
+// recordAbandoned ends a call whose outcome says nothing about the health of
+// the protected resource.
+func (b *Breaker) recordAbandoned(generation uint64) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	b.failureCount = 0
-	b.state = Closed
+	if generation == b.generation && b.state == HalfOpen {
+		b.probes--
+	}
 }
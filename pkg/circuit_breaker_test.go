@@ -0,0 +1,295 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var errBoom = errors.New("boom")
+
+func call(b *Breaker, fail bool) error {
+	return b.Execute(func() error {
+		if fail {
+			return errBoom
+		}
+		return nil
+	})
+}
+
+func TestBreaker_ConsecutiveFailures(t *testing.T) {
+	b := New(3, time.Hour)
+	for i := 0; i < 10; i++ {
+		// a success in between resets the count
+		assert.ErrorIs(t, call(b, true), errBoom)
+		assert.ErrorIs(t, call(b, true), errBoom)
+		assert.NoError(t, call(b, false))
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.ErrorIs(t, call(b, true), errBoom)
+	}
+	assert.ErrorIs(t, call(b, false), ErrOpen)
+}
+
+func TestBreaker_CountWindowTripsOnFailureRate(t *testing.T) {
+	b := New(3, time.Hour, WithCountWindow(10, 0.5, 5))
+
+	// failing 80% of the time never trips the consecutive mode, but does here
+	fails := []bool{true, true, true, true, false}
+	for i := 0; i < 4; i++ {
+		assert.Error(t, call(b, fails[i]))
+	}
+	assert.NoError(t, call(b, false), "below the minimum volume")
+	assert.ErrorIs(t, call(b, true), errBoom)
+	assert.ErrorIs(t, call(b, false), ErrOpen)
+}
+
+func TestBreaker_CountWindowForgetsOldCalls(t *testing.T) {
+	b := New(3, time.Hour, WithCountWindow(4, 0.5, 4))
+	assert.Error(t, call(b, true))
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, call(b, false))
+	}
+	// the early failure left the window, so one failure in four is 25%
+	assert.Error(t, call(b, true))
+	assert.NoError(t, call(b, false))
+}
+
+func TestBreaker_TimeWindow(t *testing.T) {
+	b := New(3, time.Hour, WithTimeWindow(50*time.Millisecond, 0.5, 2))
+	assert.Error(t, call(b, true))
+	time.Sleep(60 * time.Millisecond)
+
+	// the first failure expired, so a single failure is below the volume
+	assert.Error(t, call(b, true))
+	assert.NoError(t, call(b, false))
+	assert.Error(t, call(b, true))
+	assert.ErrorIs(t, call(b, false), ErrOpen)
+}
+
+func TestBreaker_HalfOpenAfterResetTimeout(t *testing.T) {
+	b := New(1, 10*time.Millisecond, WithCountWindow(10, 0.5, 1))
+	assert.ErrorIs(t, call(b, true), errBoom)
+	assert.ErrorIs(t, call(b, false), ErrOpen)
+
+	time.Sleep(20 * time.Millisecond)
+	assert.ErrorIs(t, call(b, true), errBoom, "a failed probe reopens")
+	assert.ErrorIs(t, call(b, false), ErrOpen)
+
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, call(b, false))
+	assert.NoError(t, call(b, false))
+}
+
+// openBreaker returns a breaker that is open and due for half-open probes.
+func openBreaker(t *testing.T, opts ...Option) *Breaker {
+	b := New(1, 10*time.Millisecond, opts...)
+	assert.ErrorIs(t, call(b, true), errBoom)
+	time.Sleep(20 * time.Millisecond)
+	return b
+}
+
+func TestBreaker_HalfOpenLimitsProbes(t *testing.T) {
+	b := openBreaker(t, WithHalfOpenProbes(2, 2))
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- b.Execute(func() error {
+				started <- struct{}{}
+				<-release
+				return nil
+			})
+		}()
+	}
+	<-started
+	<-started
+
+	assert.ErrorIs(t, call(b, false), ErrHalfOpen, "both probes are in flight")
+	close(release)
+	assert.NoError(t, <-done)
+	assert.NoError(t, <-done)
+
+	// two successful probes closed the breaker
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, call(b, false))
+	}
+}
+
+func TestBreaker_HalfOpenNeedsSuccessfulProbes(t *testing.T) {
+	b := openBreaker(t, WithHalfOpenProbes(1, 2))
+
+	assert.NoError(t, call(b, false))
+	assert.NoError(t, call(b, false))
+	assert.ErrorIs(t, call(b, true), errBoom)
+	assert.ErrorIs(t, call(b, false), ErrOpen, "two probes closed it, so a failure trips it again")
+
+	b = openBreaker(t, WithHalfOpenProbes(1, 2))
+	assert.NoError(t, call(b, false))
+	assert.ErrorIs(t, call(b, true), errBoom, "a failed probe reopens")
+	assert.ErrorIs(t, call(b, false), ErrOpen)
+}
+
+func TestBreaker_StaleCallsAreIgnored(t *testing.T) {
+	b := New(1, time.Hour)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- b.Execute(func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	assert.ErrorIs(t, call(b, true), errBoom)
+	close(release)
+	assert.NoError(t, <-done)
+
+	// the slow call started before the breaker opened, so it cannot close it
+	assert.ErrorIs(t, call(b, false), ErrOpen)
+}
+
+func TestBreaker_StateChangesAndCounts(t *testing.T) {
+	var changes []string
+	b := New(1, 10*time.Millisecond,
+		WithName("mongo"),
+		OnStateChange(func(name string, from, to State) {
+			changes = append(changes, name+": "+from.String()+" -> "+to.String())
+		}),
+	)
+
+	assert.NoError(t, call(b, false))
+	assert.ErrorIs(t, call(b, true), errBoom)
+	assert.Equal(t, Open, b.State())
+	assert.ErrorIs(t, call(b, false), ErrOpen)
+
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, call(b, false))
+	assert.Equal(t, Closed, b.State())
+
+	assert.Equal(t, []string{
+		"mongo: closed -> open",
+		"mongo: open -> half-open",
+		"mongo: half-open -> closed",
+	}, changes)
+	assert.Equal(t, Counts{Requests: 3, Successes: 2, Failures: 1, Rejections: 1}, b.Counts())
+}
+
+// wait blocks until ctx is done.
+func wait(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestBreaker_ExecuteContextTimeout(t *testing.T) {
+	b := New(2, time.Hour, WithTimeout(10*time.Millisecond))
+
+	for i := 0; i < 2; i++ {
+		assert.ErrorIs(t, b.ExecuteContext(context.Background(), wait), context.DeadlineExceeded)
+	}
+	assert.ErrorIs(t, b.ExecuteContext(context.Background(), wait), ErrOpen, "timeouts count as failures")
+}
+
+func TestBreaker_ExecuteContextCallerCancellation(t *testing.T) {
+	b := New(1, time.Hour, WithTimeout(time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	assert.ErrorIs(t, b.ExecuteContext(ctx, wait), context.Canceled)
+
+	// the caller's own deadline is not the breaker's business either
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, b.ExecuteContext(ctx, wait), context.DeadlineExceeded)
+
+	assert.Equal(t, Closed, b.State())
+	assert.Zero(t, b.Counts().Failures)
+	assert.ErrorIs(t, b.ExecuteContext(ctx, wait), context.DeadlineExceeded, "a done context is not even tried")
+	assert.Equal(t, uint64(2), b.Counts().Requests)
+}
+
+func TestBreaker_CancelledProbeFreesItsSlot(t *testing.T) {
+	b := openBreaker(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := b.ExecuteContext(ctx, func(context.Context) error {
+		cancel()
+		return context.Canceled
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, HalfOpen, b.State())
+	assert.NoError(t, call(b, false), "the cancelled probe gave its slot back")
+	assert.Equal(t, Closed, b.State())
+}
+
+func TestBreaker_IsSuccessful(t *testing.T) {
+	errNotFound := errors.New("not found")
+	b := New(2, time.Hour, WithIsSuccessful(func(err error) bool {
+		return errors.Is(err, errNotFound)
+	}))
+
+	for i := 0; i < 5; i++ {
+		err := b.Execute(func() error { return errNotFound })
+		assert.ErrorIs(t, err, errNotFound, "the error is still returned")
+	}
+	assert.Equal(t, Closed, b.State())
+	assert.Equal(t, uint64(5), b.Counts().Successes)
+
+	// an accepted error breaks a run of failures
+	assert.ErrorIs(t, call(b, true), errBoom)
+	assert.ErrorIs(t, b.Execute(func() error { return errNotFound }), errNotFound)
+	assert.ErrorIs(t, call(b, true), errBoom)
+	assert.Equal(t, Closed, b.State())
+	assert.ErrorIs(t, call(b, true), errBoom)
+	assert.Equal(t, Open, b.State())
+}
+
+func TestCountWindow(t *testing.T) {
+	w := newCountWindow(3)
+	now := time.Now()
+	for _, failed := range []bool{true, false, true, true} {
+		w.record(now, failed)
+	}
+	requests, failures := w.counts(now)
+	assert.Equal(t, 3, requests)
+	assert.Equal(t, 2, failures)
+
+	w.reset()
+	requests, failures = w.counts(now)
+	assert.Zero(t, requests)
+	assert.Zero(t, failures)
+}
+
+func TestTimeWindow(t *testing.T) {
+	w := newTimeWindow(time.Second)
+	start := time.Unix(1000, 0)
+	w.record(start, true)
+	w.record(start.Add(500*time.Millisecond), false)
+
+	requests, failures := w.counts(start.Add(900 * time.Millisecond))
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, 1, failures)
+
+	requests, failures = w.counts(start.Add(1200 * time.Millisecond))
+	assert.Equal(t, 1, requests)
+	assert.Equal(t, 0, failures)
+
+	// a bucket that is reused after a full span starts over
+	w.record(start.Add(time.Second), false)
+	requests, failures = w.counts(start.Add(time.Second))
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, 0, failures)
+}
@@ -0,0 +1,82 @@
+package circuitbreaker
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Snapshot is the state of a breaker at one point in time.
+type Snapshot struct {
+	Name   string `json:"name"`
+	State  State  `json:"state"`
+	Counts Counts `json:"counts"`
+}
+
+// Registry keeps track of the breakers of a process, so their state can be
+// inspected and exported as metrics.
+type Registry struct {
+	mu       sync.Mutex
+	breakers []*Breaker
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) Add(b *Breaker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.breakers = append(r.breakers, b)
+}
+
+// Snapshots returns the state of every breaker in the order they were added.
+func (r *Registry) Snapshots() []Snapshot {
+	r.mu.Lock()
+	breakers := append([]*Breaker(nil), r.breakers...)
+	r.mu.Unlock()
+
+	snapshots := make([]Snapshot, 0, len(breakers))
+	for _, b := range breakers {
+		b.mu.Lock()
+		snapshots = append(snapshots, Snapshot{Name: b.name, State: b.state, Counts: b.counts})
+		b.mu.Unlock()
+	}
+	return snapshots
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WriteMetrics writes the state and call totals of every breaker in the
+// Prometheus text format.
+func (r *Registry) WriteMetrics(w io.Writer) error {
+	snapshots := r.Snapshots()
+	metrics := []struct {
+		name, kind, help string
+		value            func(Snapshot) uint64
+	}{
+		{"circuit_breaker_state", "gauge", "State of the circuit breaker: 0 closed, 1 open, 2 half-open.",
+			func(s Snapshot) uint64 { return uint64(s.State) }},
+		{"circuit_breaker_requests_total", "counter", "Calls the circuit breaker let through.",
+			func(s Snapshot) uint64 { return s.Counts.Requests }},
+		{"circuit_breaker_successes_total", "counter", "Calls that succeeded.",
+			func(s Snapshot) uint64 { return s.Counts.Successes }},
+		{"circuit_breaker_failures_total", "counter", "Calls that failed.",
+			func(s Snapshot) uint64 { return s.Counts.Failures }},
+		{"circuit_breaker_rejections_total", "counter", "Calls rejected while the circuit breaker was open or half-open.",
+			func(s Snapshot) uint64 { return s.Counts.Rejections }},
+	}
+
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind); err != nil {
+			return err
+		}
+		for _, s := range snapshots {
+			if _, err := fmt.Fprintf(w, "%s{name=\"%s\"} %d\n", m.name, labelEscaper.Replace(s.Name), m.value(s)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package circuitbreaker
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_WriteMetrics(t *testing.T) {
+	r := NewRegistry()
+	mongo := New(1, time.Hour, WithName("mongo"))
+	r.Add(mongo)
+	r.Add(New(1, time.Hour, WithName(`odd "name"`)))
+
+	assert.ErrorIs(t, call(mongo, true), errBoom)
+	assert.ErrorIs(t, call(mongo, false), ErrOpen)
+
+	snapshots := r.Snapshots()
+	assert.Len(t, snapshots, 2)
+	assert.Equal(t, Snapshot{Name: "mongo", State: Open, Counts: Counts{Requests: 1, Failures: 1, Rejections: 1}}, snapshots[0])
+
+	var buf strings.Builder
+	assert.NoError(t, r.WriteMetrics(&buf))
+	metrics := buf.String()
+	assert.Contains(t, metrics, "# TYPE circuit_breaker_state gauge\n")
+	assert.Contains(t, metrics, `circuit_breaker_state{name="mongo"} 1`+"\n")
+	assert.Contains(t, metrics, `circuit_breaker_state{name="odd \"name\""} 0`+"\n")
+	assert.Contains(t, metrics, `circuit_breaker_failures_total{name="mongo"} 1`+"\n")
+	assert.Contains(t, metrics, `circuit_breaker_rejections_total{name="mongo"} 1`+"\n")
+}
@@ -0,0 +1,94 @@
+package circuitbreaker
+
+import "time"
+
+// window counts the outcome of recent calls.
+type window interface {
+	record(now time.Time, failed bool)
+	counts(now time.Time) (requests, failures int)
+	reset()
+}
+
+// countWindow keeps the outcome of the last size calls in a ring.
+type countWindow struct {
+	outcomes []bool // true for a failure
+	next     int
+	filled   int
+	failures int
+}
+
+func newCountWindow(size int) *countWindow {
+	return &countWindow{outcomes: make([]bool, max(size, 1))}
+}
+
+func (w *countWindow) record(_ time.Time, failed bool) {
+	if w.filled == len(w.outcomes) && w.outcomes[w.next] {
+		w.failures--
+	}
+	w.outcomes[w.next] = failed
+	if failed {
+		w.failures++
+	}
+	w.next = (w.next + 1) % len(w.outcomes)
+	w.filled = min(w.filled+1, len(w.outcomes))
+}
+
+func (w *countWindow) counts(time.Time) (int, int) {
+	return w.filled, w.failures
+}
+
+func (w *countWindow) reset() {
+	clear(w.outcomes)
+	w.next, w.filled, w.failures = 0, 0, 0
+}
+
+// timeBuckets is the resolution of a time window.
+const timeBuckets = 10
+
+// timeWindow counts the calls of the last span in timeBuckets buckets, so
+// calls leave the window one bucket at a time.
+type timeWindow struct {
+	span    time.Duration
+	buckets [timeBuckets]bucket
+}
+
+type bucket struct {
+	start    time.Time
+	requests int
+	failures int
+}
+
+func newTimeWindow(span time.Duration) *timeWindow {
+	return &timeWindow{span: max(span, timeBuckets)}
+}
+
+func (w *timeWindow) width() time.Duration {
+	return w.span / timeBuckets
+}
+
+func (w *timeWindow) record(now time.Time, failed bool) {
+	start := now.Truncate(w.width())
+	b := &w.buckets[(start.UnixNano()/int64(w.width()))%timeBuckets]
+	if !b.start.Equal(start) {
+		*b = bucket{start: start}
+	}
+	b.requests++
+	if failed {
+		b.failures++
+	}
+}
+
+func (w *timeWindow) counts(now time.Time) (requests, failures int) {
+	oldest := now.Truncate(w.width()).Add(-w.span + w.width())
+	for _, b := range w.buckets {
+		if !b.start.Before(oldest) && !b.start.After(now) {
+			requests += b.requests
+			failures += b.failures
+		}
+	}
+	return requests, failures
+}
+
+func (w *timeWindow) reset() {
+	w.buckets = [timeBuckets]bucket{}
+}